@@ -0,0 +1,73 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// ValidateArchive checks if the zip archive read from `r` is a package built by vcpkg for the port `name`.
+// The archive must contain `CONTROL` file of the package and `share/{name}/vcpkg_abi_info.txt`.
+func ValidateArchive(r io.ReaderAt, size int64, name string) error {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidArchive, err.Error())
+	}
+
+	var control *zip.File
+	abi_info := path.Join("share", name, "vcpkg_abi_info.txt")
+	has_abi_info := false
+	for _, f := range archive.File {
+		switch path.Clean(strings.ReplaceAll(f.Name, "\\", "/")) {
+		case "CONTROL":
+			control = f
+		case abi_info:
+			has_abi_info = true
+		}
+	}
+
+	if control == nil {
+		return fmt.Errorf("%w: CONTROL not found", ErrInvalidArchive)
+	}
+	if !has_abi_info {
+		return fmt.Errorf("%w: %s not found", ErrInvalidArchive, abi_info)
+	}
+
+	package_name, err := readControlPackageName(control)
+	if err != nil {
+		return fmt.Errorf("%w: read CONTROL: %s", ErrInvalidArchive, err.Error())
+	}
+	if package_name != name {
+		return fmt.Errorf("%w: package name is %q but expected %q", ErrInvalidArchive, package_name, name)
+	}
+
+	return nil
+}
+
+// readControlPackageName returns the value of the first `Package` field in the CONTROL file.
+func readControlPackageName(f *zip.File) (string, error) {
+	r, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || strings.TrimSpace(key) != "Package" {
+			continue
+		}
+
+		return strings.TrimSpace(value), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", errors.New("no Package field")
+}
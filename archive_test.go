@@ -0,0 +1,171 @@
+package main_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	main "github.com/lesomnus/vcpkg-cache-http"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestArchive(t *testing.T, files map[string]string) []byte {
+	require := require.New(t)
+
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for name, content := range files {
+		f, err := w.Create(name)
+		require.NoError(err)
+		_, err = f.Write([]byte(content))
+		require.NoError(err)
+	}
+
+	err := w.Close()
+	require.NoError(err)
+
+	return b.Bytes()
+}
+
+func newTestPackage(t *testing.T, name string) []byte {
+	return newTestArchive(t, map[string]string{
+		"BUILD_INFO": "CRTLinkage: dynamic\n",
+		"CONTROL":    "Package: " + name + "\nVersion: 1.0\nArchitecture: x64-linux\n\nPackage: " + name + "\nFeature: foo\n",
+
+		"include/" + name + ".h":                     "",
+		"share/" + name + "/copyright":               "",
+		"share/" + name + "/vcpkg_abi_info.txt":      "",
+		"share/" + name + "/vcpkg-port-config.cmake": "",
+	})
+}
+
+func TestValidateArchive(t *testing.T) {
+	test_cases := []struct {
+		desc string
+		data []byte
+		msg  string
+	}{
+		{
+			desc: "not a zip",
+			data: []byte("<html>Bad Gateway</html>"),
+			msg:  "zip",
+		},
+		{
+			desc: "no CONTROL",
+			data: newTestArchive(t, map[string]string{"share/foo/vcpkg_abi_info.txt": ""}),
+			msg:  "CONTROL not found",
+		},
+		{
+			desc: "no ABI info",
+			data: newTestArchive(t, map[string]string{"CONTROL": "Package: foo\n"}),
+			msg:  "vcpkg_abi_info.txt not found",
+		},
+		{
+			desc: "no Package field",
+			data: newTestArchive(t, map[string]string{"CONTROL": "Version: 1.0\n", "share/foo/vcpkg_abi_info.txt": ""}),
+			msg:  "no Package field",
+		},
+		{
+			desc: "package name mismatch",
+			data: newTestArchive(t, map[string]string{"CONTROL": "Package: bar\n", "share/foo/vcpkg_abi_info.txt": ""}),
+			msg:  `package name is "bar"`,
+		},
+		{
+			desc: "package of other port",
+			data: newTestPackage(t, "bar"),
+			msg:  "share/foo/vcpkg_abi_info.txt not found",
+		},
+	}
+	for _, tc := range test_cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := main.ValidateArchive(bytes.NewReader(tc.data), int64(len(tc.data)), "foo")
+			require.ErrorIs(t, err, main.ErrInvalidArchive)
+			require.ErrorContains(t, err, tc.msg)
+		})
+	}
+
+	t.Run("valid package", func(t *testing.T) {
+		data := newTestPackage(t, "foo")
+		err := main.ValidateArchive(bytes.NewReader(data), int64(len(data)), "foo")
+		require.NoError(t, err)
+	})
+}
+
+func TestServerPutValidating(t *testing.T) {
+	t.Run("422 if archive is invalid", WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		handler.IsValidating = true
+
+		req := httptest.NewRequest(http.MethodPut, DescriptionFoo.String(), bytes.NewReader(randomData(t)))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		res := w.Result()
+		require.Equal(http.StatusUnprocessableEntity, res.StatusCode)
+
+		_, err := store.Head(req.Context(), DescriptionFoo)
+		require.ErrorIs(err, main.ErrNotExist)
+	}))
+
+	t.Run("200 if archive is valid", WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		handler.IsValidating = true
+
+		data := newTestPackage(t, DescriptionFoo.Name)
+		req := httptest.NewRequest(http.MethodPut, DescriptionFoo.String(), bytes.NewReader(data))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		res := w.Result()
+		require.Equal(http.StatusOK, res.StatusCode)
+
+		received, err := readAll(req.Context(), store, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(data, received)
+	}))
+
+	t.Run("upload is spooled to the spool directory", WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		spool := filepath.Join(t.TempDir(), "spool")
+		spooled := ""
+
+		handler.IsValidating = true
+		handler.SpoolDir = spool
+		handler.Store = &spyingStore{Store: store, put: func(r io.Reader) {
+			if f, ok := r.(*os.File); ok {
+				spooled = filepath.Dir(f.Name())
+			}
+		}}
+
+		req := httptest.NewRequest(http.MethodPut, DescriptionFoo.String(), bytes.NewReader(newTestPackage(t, DescriptionFoo.Name)))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(http.StatusOK, w.Result().StatusCode)
+		require.Equal(spool, spooled)
+
+		// Spooled file is removed.
+		entries, err := os.ReadDir(spool)
+		require.NoError(err)
+		require.Empty(entries)
+	}))
+}
+
+// spyingStore calls `put` with the reader given to `Put`.
+type spyingStore struct {
+	main.Store
+	put func(r io.Reader)
+}
+
+func (s *spyingStore) Put(ctx context.Context, desc main.Description, r io.Reader) error {
+	s.put(r)
+	return s.Store.Put(ctx, desc, r)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type Permission uint8
+
+const (
+	PermRead Permission = 1 << iota
+	PermWrite
+	PermDelete
+
+	PermNone Permission = 0
+)
+
+func (p Permission) Has(q Permission) bool {
+	return p&q == q
+}
+
+func (p Permission) String() string {
+	rst := ""
+	for _, v := range []struct {
+		perm Permission
+		c    string
+	}{
+		{PermRead, "r"},
+		{PermWrite, "w"},
+		{PermDelete, "d"},
+	} {
+		if p.Has(v.perm) {
+			rst += v.c
+		}
+	}
+
+	return rst
+}
+
+// Client is an authenticated client.
+type Client struct {
+	Name       string
+	Permission Permission
+}
+
+var ErrUnauthorized = errors.New("unauthorized")
+
+type Authenticator interface {
+	// Authenticate identifies the client who sent the request.
+	// It returns `ErrUnauthorized` if the request does not have valid credentials.
+	Authenticate(req *http.Request) (Client, error)
+
+	// Challenges returns values for "WWW-Authenticate" header.
+	Challenges() []string
+}
+
+type clientCtxKey struct{}
+
+func withClient(ctx context.Context, client Client) context.Context {
+	return context.WithValue(ctx, clientCtxKey{}, client)
+}
+
+// ClientFromContext returns the authenticated client of the request.
+func ClientFromContext(ctx context.Context) (Client, bool) {
+	client, ok := ctx.Value(clientCtxKey{}).(Client)
+	return client, ok
+}
+
+type AuthConfig struct {
+	// Bearer tokens for each client name.
+	ReadTokens   map[string]string `json:"read_tokens,omitempty"`
+	WriteTokens  map[string]string `json:"write_tokens,omitempty"`
+	DeleteTokens map[string]string `json:"delete_tokens,omitempty"`
+
+	// Path to htpasswd file for HTTP Basic authentication.
+	Htpasswd string `json:"htpasswd,omitempty"`
+
+	// Roles of the users in htpasswd file;
+	// one of "read", "write", "delete", "read-write", "read-write-delete" or "none".
+	Roles map[string]string `json:"roles,omitempty"`
+
+	// Roles of the clients authenticated by TLS client certificates.
+	// Key is either common name or whole subject of the certificate, e.g. "CN=ci,O=Example".
+	CertRoles map[string]string `json:"cert_roles,omitempty"`
+
+	// Role for the users not listed and for the certificates not listed
+	// if the client has no other credentials, which is "none" by default.
+	DefaultRole string `json:"default_role,omitempty"`
+}
+
+// NewAuthenticator creates an authenticator from the config.
+// It returns nil if no authentication is configured.
+func NewAuthenticator(conf *AuthConfig) (Authenticator, error) {
+	if conf == nil {
+		return nil, nil
+	}
+
+	auths := authChain{}
+	if len(conf.ReadTokens) > 0 || len(conf.WriteTokens) > 0 || len(conf.DeleteTokens) > 0 {
+		auth, err := newBearerAuth(conf)
+		if err != nil {
+			return nil, fmt.Errorf("bearer: %w", err)
+		}
+
+		auths = append(auths, auth)
+	}
+	if len(conf.CertRoles) > 0 {
+		auth, err := newCertAuth(conf)
+		if err != nil {
+			return nil, fmt.Errorf("certificate: %w", err)
+		}
+
+		auths = append(auths, auth)
+	}
+	if conf.Htpasswd != "" {
+		auth, err := newBasicAuth(conf)
+		if err != nil {
+			return nil, fmt.Errorf("basic: %w", err)
+		}
+
+		auths = append(auths, auth)
+	}
+	if len(conf.CertRoles) > 0 && conf.DefaultRole != "" {
+		perm, err := parseRole(conf.DefaultRole)
+		if err != nil {
+			return nil, fmt.Errorf("certificate: default role: %w", err)
+		}
+
+		// Other credentials of the client are tried first.
+		auths = append(auths, &certDefaultAuth{perm: perm})
+	}
+
+	switch len(auths) {
+	case 0:
+		return nil, nil
+	case 1:
+		return auths[0], nil
+	default:
+		return auths, nil
+	}
+}
+
+// authChain authenticates the client by the first authenticator that succeeds.
+type authChain []Authenticator
+
+func (c authChain) Authenticate(req *http.Request) (Client, error) {
+	for _, auth := range c {
+		client, err := auth.Authenticate(req)
+		if errors.Is(err, ErrUnauthorized) {
+			continue
+		}
+
+		return client, err
+	}
+
+	return Client{}, ErrUnauthorized
+}
+
+func (c authChain) Challenges() []string {
+	rst := []string{}
+	for _, auth := range c {
+		rst = append(rst, auth.Challenges()...)
+	}
+
+	return rst
+}
+
+type bearerToken struct {
+	name string
+	perm Permission
+}
+
+// bearerAuth authenticates the client by "Authorization: Bearer <token>" header.
+type bearerAuth struct {
+	// Tokens are indexed by their digest so that
+	// lookup time does not depend on how much of the token matches.
+	tokens map[[sha256.Size]byte]bearerToken
+}
+
+func newBearerAuth(conf *AuthConfig) (*bearerAuth, error) {
+	a := &bearerAuth{tokens: map[[sha256.Size]byte]bearerToken{}}
+
+	add := func(tokens map[string]string, perm Permission) error {
+		for name, token := range tokens {
+			if token == "" {
+				return fmt.Errorf("token of %s is empty", name)
+			}
+
+			key := sha256.Sum256([]byte(token))
+			v, ok := a.tokens[key]
+			if ok && v.name != name {
+				return fmt.Errorf("token of %s is same with the token of %s", name, v.name)
+			}
+
+			a.tokens[key] = bearerToken{name: name, perm: v.perm | perm}
+		}
+
+		return nil
+	}
+
+	if err := add(conf.ReadTokens, PermRead); err != nil {
+		return nil, err
+	}
+	if err := add(conf.WriteTokens, PermWrite); err != nil {
+		return nil, err
+	}
+	if err := add(conf.DeleteTokens, PermDelete); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *bearerAuth) Authenticate(req *http.Request) (Client, error) {
+	scheme, token, ok := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return Client{}, ErrUnauthorized
+	}
+
+	v, ok := a.tokens[sha256.Sum256([]byte(strings.TrimSpace(token)))]
+	if !ok {
+		return Client{}, ErrUnauthorized
+	}
+
+	return Client{Name: v.name, Permission: v.perm}, nil
+}
+
+func (a *bearerAuth) Challenges() []string {
+	return []string{`Bearer realm="vcpkg-cache-http"`}
+}
+
+// verifiedSubject returns the subject of the verified TLS client certificate of the request.
+func verifiedSubject(req *http.Request) (pkix.Name, bool) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return pkix.Name{}, false
+	}
+
+	return req.TLS.VerifiedChains[0][0].Subject, true
+}
+
+// certAuth authenticates the client by verified TLS client certificate listed in the roles.
+// Clients with other certificates are left to the other authenticators.
+type certAuth struct {
+	roles map[string]Permission
+}
+
+func newCertAuth(conf *AuthConfig) (*certAuth, error) {
+	a := &certAuth{roles: map[string]Permission{}}
+	for subject, role := range conf.CertRoles {
+		perm, err := parseRole(role)
+		if err != nil {
+			return nil, fmt.Errorf("role of %s: %w", subject, err)
+		}
+
+		a.roles[subject] = perm
+	}
+
+	return a, nil
+}
+
+func (a *certAuth) Authenticate(req *http.Request) (Client, error) {
+	subject, ok := verifiedSubject(req)
+	if !ok {
+		return Client{}, ErrUnauthorized
+	}
+
+	name := subject.String()
+	if perm, ok := a.roles[name]; ok {
+		return Client{Name: name, Permission: perm}, nil
+	}
+	if perm, ok := a.roles[subject.CommonName]; ok {
+		return Client{Name: subject.CommonName, Permission: perm}, nil
+	}
+
+	return Client{}, ErrUnauthorized
+}
+
+func (a *certAuth) Challenges() []string {
+	return []string{}
+}
+
+// certDefaultAuth authenticates the client by any verified TLS client certificate with the default role.
+type certDefaultAuth struct {
+	perm Permission
+}
+
+func (a *certDefaultAuth) Authenticate(req *http.Request) (Client, error) {
+	subject, ok := verifiedSubject(req)
+	if !ok {
+		return Client{}, ErrUnauthorized
+	}
+
+	return Client{Name: subject.String(), Permission: a.perm}, nil
+}
+
+func (a *certDefaultAuth) Challenges() []string {
+	return []string{}
+}
@@ -0,0 +1,317 @@
+package main_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	main "github.com/lesomnus/vcpkg-cache-http"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPermission(t *testing.T) {
+	require := require.New(t)
+
+	perm := main.PermRead | main.PermWrite
+	require.True(perm.Has(main.PermRead))
+	require.True(perm.Has(main.PermWrite))
+	require.True(perm.Has(main.PermRead | main.PermWrite))
+	require.False(perm.Has(main.PermDelete))
+	require.Equal("rw", perm.String())
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	t.Run("nil if not configured", func(t *testing.T) {
+		require := require.New(t)
+
+		auth, err := main.NewAuthenticator(nil)
+		require.NoError(err)
+		require.Nil(auth)
+
+		auth, err = main.NewAuthenticator(&main.AuthConfig{})
+		require.NoError(err)
+		require.Nil(auth)
+	})
+
+	t.Run("token cannot be empty", func(t *testing.T) {
+		require := require.New(t)
+
+		_, err := main.NewAuthenticator(&main.AuthConfig{
+			ReadTokens: map[string]string{"foo": ""},
+		})
+		require.ErrorContains(err, "empty")
+	})
+
+	t.Run("token cannot be shared by clients", func(t *testing.T) {
+		require := require.New(t)
+
+		_, err := main.NewAuthenticator(&main.AuthConfig{
+			ReadTokens:  map[string]string{"foo": "secret"},
+			WriteTokens: map[string]string{"bar": "secret"},
+		})
+		require.ErrorContains(err, "same")
+	})
+}
+
+func TestBearerAuth(t *testing.T) {
+	auth, err := main.NewAuthenticator(&main.AuthConfig{
+		ReadTokens:   map[string]string{"reader": "r-token", "both": "rw-token", "admin": "rwd-token"},
+		WriteTokens:  map[string]string{"writer": "w-token", "both": "rw-token", "admin": "rwd-token"},
+		DeleteTokens: map[string]string{"deleter": "d-token", "admin": "rwd-token"},
+	})
+	require.NoError(t, err)
+
+	test_cases := []struct {
+		header string
+		name   string
+		perm   main.Permission
+	}{
+		{header: "Bearer r-token", name: "reader", perm: main.PermRead},
+		{header: "bearer r-token", name: "reader", perm: main.PermRead},
+		{header: "Bearer w-token", name: "writer", perm: main.PermWrite},
+		{header: "Bearer rw-token", name: "both", perm: main.PermRead | main.PermWrite},
+		{header: "Bearer d-token", name: "deleter", perm: main.PermDelete},
+		{header: "Bearer rwd-token", name: "admin", perm: main.PermRead | main.PermWrite | main.PermDelete},
+	}
+	for _, tc := range test_cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", tc.header)
+
+		client, err := auth.Authenticate(req)
+		require.NoError(t, err, tc.header)
+		require.Equal(t, main.Client{Name: tc.name, Permission: tc.perm}, client)
+	}
+
+	for _, header := range []string{"", "Bearer", "Bearer ", "Bearer foo", "Basic r-token", "r-token"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", header)
+
+		_, err := auth.Authenticate(req)
+		require.ErrorIs(t, err, main.ErrUnauthorized, header)
+	}
+}
+
+func writeHtpasswd(t *testing.T, p string, users map[string]string) {
+	require := require.New(t)
+
+	data := "# comment\n\n"
+	for user, pass := range users {
+		hash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.MinCost)
+		require.NoError(err)
+
+		data += user + ":" + string(hash) + "\n"
+	}
+
+	err := os.WriteFile(p, []byte(data), 0600)
+	require.NoError(err)
+}
+
+func TestBasicAuth(t *testing.T) {
+	authenticate := func(auth main.Authenticator, user string, pass string) (main.Client, error) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(user, pass)
+		return auth.Authenticate(req)
+	}
+
+	t.Run("users get their roles", func(t *testing.T) {
+		require := require.New(t)
+
+		htpasswd := filepath.Join(t.TempDir(), "htpasswd")
+		writeHtpasswd(t, htpasswd, map[string]string{
+			"alice": "foo",
+			"bob":   "bar",
+			"carol": "baz",
+			"erin":  "qux",
+		})
+
+		auth, err := main.NewAuthenticator(&main.AuthConfig{
+			Htpasswd: htpasswd,
+			Roles: map[string]string{
+				"alice": "read-write",
+				"bob":   "write",
+				"erin":  "read-write-delete",
+			},
+			DefaultRole: "read",
+		})
+		require.NoError(err)
+
+		for i := 0; i < 2; i++ {
+			client, err := authenticate(auth, "alice", "foo")
+			require.NoError(err)
+			require.Equal(main.Client{Name: "alice", Permission: main.PermRead | main.PermWrite}, client)
+		}
+
+		client, err := authenticate(auth, "bob", "bar")
+		require.NoError(err)
+		require.Equal(main.Client{Name: "bob", Permission: main.PermWrite}, client)
+
+		client, err = authenticate(auth, "erin", "qux")
+		require.NoError(err)
+		require.Equal(main.Client{Name: "erin", Permission: main.PermRead | main.PermWrite | main.PermDelete}, client)
+
+		client, err = authenticate(auth, "carol", "baz")
+		require.NoError(err)
+		require.Equal(main.Client{Name: "carol", Permission: main.PermRead}, client)
+
+		_, err = authenticate(auth, "alice", "bar")
+		require.ErrorIs(err, main.ErrUnauthorized)
+
+		_, err = authenticate(auth, "dave", "foo")
+		require.ErrorIs(err, main.ErrUnauthorized)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		_, err = auth.Authenticate(req)
+		require.ErrorIs(err, main.ErrUnauthorized)
+	})
+
+	t.Run("htpasswd file is reloaded if it is modified", func(t *testing.T) {
+		require := require.New(t)
+
+		htpasswd := filepath.Join(t.TempDir(), "htpasswd")
+		writeHtpasswd(t, htpasswd, map[string]string{"alice": "foo"})
+
+		auth, err := main.NewAuthenticator(&main.AuthConfig{
+			Htpasswd:    htpasswd,
+			DefaultRole: "read",
+		})
+		require.NoError(err)
+
+		_, err = authenticate(auth, "alice", "foo")
+		require.NoError(err)
+
+		writeHtpasswd(t, htpasswd, map[string]string{"alice": "bar", "bob": "baz"})
+		mod_time := time.Now().Add(time.Minute)
+		err = os.Chtimes(htpasswd, mod_time, mod_time)
+		require.NoError(err)
+
+		_, err = authenticate(auth, "alice", "foo")
+		require.ErrorIs(err, main.ErrUnauthorized)
+
+		_, err = authenticate(auth, "alice", "bar")
+		require.NoError(err)
+
+		_, err = authenticate(auth, "bob", "baz")
+		require.NoError(err)
+	})
+
+	t.Run("bearer and basic can be used together", func(t *testing.T) {
+		require := require.New(t)
+
+		htpasswd := filepath.Join(t.TempDir(), "htpasswd")
+		writeHtpasswd(t, htpasswd, map[string]string{"alice": "foo"})
+
+		auth, err := main.NewAuthenticator(&main.AuthConfig{
+			ReadTokens:  map[string]string{"ci": "token"},
+			Htpasswd:    htpasswd,
+			DefaultRole: "rw",
+		})
+		require.NoError(err)
+		require.Len(auth.Challenges(), 2)
+
+		_, err = authenticate(auth, "alice", "foo")
+		require.NoError(err)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer token")
+		client, err := auth.Authenticate(req)
+		require.NoError(err)
+		require.Equal("ci", client.Name)
+	})
+
+	t.Run("fail if htpasswd file is invalid", func(t *testing.T) {
+		require := require.New(t)
+
+		_, err := main.NewAuthenticator(&main.AuthConfig{Htpasswd: filepath.Join(t.TempDir(), "not-exists")})
+		require.ErrorContains(err, "htpasswd")
+
+		htpasswd := filepath.Join(t.TempDir(), "htpasswd")
+		for _, data := range []string{
+			"alice",
+			":$2y$05$abcdefghijklmnopqrstuv",
+			"alice:{SHA}C+7Hteo/D9vJXQ3UfzxbwnXaijM=",
+		} {
+			err := os.WriteFile(htpasswd, []byte(data), 0600)
+			require.NoError(err)
+
+			_, err = main.NewAuthenticator(&main.AuthConfig{Htpasswd: htpasswd})
+			require.ErrorContains(err, "line 1", data)
+		}
+	})
+
+	t.Run("fail if role is invalid", func(t *testing.T) {
+		require := require.New(t)
+
+		htpasswd := filepath.Join(t.TempDir(), "htpasswd")
+		writeHtpasswd(t, htpasswd, map[string]string{"alice": "foo"})
+
+		_, err := main.NewAuthenticator(&main.AuthConfig{
+			Htpasswd: htpasswd,
+			Roles:    map[string]string{"alice": "admin"},
+		})
+		require.ErrorContains(err, "invalid role")
+
+		_, err = main.NewAuthenticator(&main.AuthConfig{
+			Htpasswd:    htpasswd,
+			DefaultRole: "admin",
+		})
+		require.ErrorContains(err, "invalid role")
+	})
+}
+
+func TestCertAuth(t *testing.T) {
+	withCert := func(req *http.Request, subject pkix.Name) *http.Request {
+		req.TLS = &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{{Subject: subject}}},
+		}
+		return req
+	}
+
+	ci := pkix.Name{CommonName: "ci", Organization: []string{"Example"}}
+	other := pkix.Name{CommonName: "other"}
+
+	t.Run("certificates listed get their roles", func(t *testing.T) {
+		require := require.New(t)
+
+		auth, err := main.NewAuthenticator(&main.AuthConfig{
+			CertRoles: map[string]string{"ci": "read-write"},
+		})
+		require.NoError(err)
+
+		client, err := auth.Authenticate(withCert(httptest.NewRequest(http.MethodGet, "/", nil), ci))
+		require.NoError(err)
+		require.Equal(main.Client{Name: "ci", Permission: main.PermRead | main.PermWrite}, client)
+
+		_, err = auth.Authenticate(withCert(httptest.NewRequest(http.MethodGet, "/", nil), other))
+		require.ErrorIs(err, main.ErrUnauthorized)
+	})
+
+	t.Run("certificates not listed are left to other credentials", func(t *testing.T) {
+		require := require.New(t)
+
+		auth, err := main.NewAuthenticator(&main.AuthConfig{
+			ReadTokens:  map[string]string{"reader": "r-token"},
+			CertRoles:   map[string]string{"ci": "read-write"},
+			DefaultRole: "none",
+		})
+		require.NoError(err)
+
+		req := withCert(httptest.NewRequest(http.MethodGet, "/", nil), other)
+		req.Header.Set("Authorization", "Bearer r-token")
+
+		client, err := auth.Authenticate(req)
+		require.NoError(err)
+		require.Equal(main.Client{Name: "reader", Permission: main.PermRead}, client)
+
+		// Default role is given if there are no other credentials.
+		client, err = auth.Authenticate(withCert(httptest.NewRequest(http.MethodGet, "/", nil), other))
+		require.NoError(err)
+		require.Equal(main.Client{Name: "CN=other", Permission: main.PermNone}, client)
+	})
+}
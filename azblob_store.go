@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+// Default size of the blocks of uploads.
+const azblobStoreDefaultBlockSize = 4 << 20
+
+type azblobStore struct {
+	client *container.Client
+	prefix string
+
+	// Size of the blocks an upload is staged by.
+	// Each block is buffered in memory.
+	block_size int64
+}
+
+type azblobOption func(s *azblobStore)
+
+func WithAzblobPrefix(p string) azblobOption {
+	return func(s *azblobStore) {
+		s.prefix = strings.Trim(p, "/")
+	}
+}
+
+// WithAzblobBlockSize sets the size of the blocks an upload is staged by.
+func WithAzblobBlockSize(size int64) azblobOption {
+	return func(s *azblobStore) {
+		s.block_size = size
+	}
+}
+
+func NewAzblobStore(client *container.Client, opts ...azblobOption) (*azblobStore, error) {
+	s := &azblobStore{client: client, block_size: azblobStoreDefaultBlockSize}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.block_size <= 0 {
+		return nil, errors.New("block size must be positive")
+	}
+
+	if _, err := s.client.GetProperties(context.Background(), nil); err != nil {
+		if bloberror.HasCode(err, bloberror.ContainerNotFound) {
+			return nil, fmt.Errorf("container not found: %s", client.URL())
+		}
+		return nil, fmt.Errorf("check container: %w", err)
+	}
+
+	return s, nil
+}
+
+// newAzblobStoreFromConfig creates a store from options such as:
+//
+//	azblob:,account=vcpkg,container=cache,prefix=x64-windows
+//
+// The container is authorized by a shared key given by "account_key" option or
+// `AZURE_STORAGE_KEY` environment variable, or by a SAS token given by "sas" option or
+// `AZURE_STORAGE_SAS_TOKEN` environment variable.
+func newAzblobStoreFromConfig(conf *StoreConfig) (*azblobStore, error) {
+	account := conf.Opts["account"]
+	if account == "" {
+		return nil, errors.New("account must be specified")
+	}
+
+	container_name := conf.Opts["container"]
+	if container_name == "" {
+		return nil, errors.New("container must be specified")
+	}
+
+	endpoint := conf.Opts["endpoint"]
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", account)
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+
+	container_url := strings.TrimSuffix(endpoint, "/") + "/" + container_name
+
+	opts := []azblobOption{WithAzblobPrefix(conf.Opts["prefix"])}
+	if v, ok := conf.Opts["block_size"]; ok {
+		size, err := parseSize(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for option block_size: %w", err)
+		}
+
+		opts = append(opts, WithAzblobBlockSize(size))
+	}
+
+	account_key, has_key := conf.Opts["account_key"]
+	sas, has_sas := conf.Opts["sas"]
+	if has_key && has_sas {
+		return nil, errors.New("only one of account_key and sas can be given")
+	}
+	if !has_key && !has_sas {
+		account_key = os.Getenv("AZURE_STORAGE_KEY")
+		sas = os.Getenv("AZURE_STORAGE_SAS_TOKEN")
+		has_key = account_key != ""
+		has_sas = !has_key && sas != ""
+	}
+
+	var (
+		client *container.Client
+		err    error
+	)
+	switch {
+	case has_key:
+		cred, err_cred := container.NewSharedKeyCredential(account, account_key)
+		if err_cred != nil {
+			return nil, fmt.Errorf("create shared key credential: %w", err_cred)
+		}
+
+		client, err = container.NewClientWithSharedKeyCredential(container_url, cred, nil)
+
+	case has_sas:
+		client, err = container.NewClientWithNoCredential(container_url+"?"+strings.TrimPrefix(sas, "?"), nil)
+
+	default:
+		return nil, errors.New("credentials must be given by account_key or sas")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create Azure Blob Storage client: %w", err)
+	}
+
+	return NewAzblobStore(client, opts...)
+}
+
+func (s *azblobStore) Resolve(desc Description) string {
+	return path.Join(s.prefix, desc.Name, desc.Version, desc.Hash)
+}
+
+func azblobError(err error) error {
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return fmt.Errorf("%w: %s", ErrNotExist, err.Error())
+	}
+
+	return err
+}
+
+func (s *azblobStore) blob(desc Description) *blockblob.Client {
+	return s.client.NewBlockBlobClient(s.Resolve(desc))
+}
+
+func azblobInfo(props blob.GetPropertiesResponse) Info {
+	info := Info{Size: -1, Seekable: true}
+	if props.ContentLength != nil {
+		info.Size = *props.ContentLength
+	}
+	if props.LastModified != nil {
+		info.ModTime = *props.LastModified
+	}
+
+	return info
+}
+
+// Get reads the properties of the blob and the blob is downloaded on the first read
+// so it is not downloaded twice when it is seeked before the read, e.g. by range requests.
+func (s *azblobStore) Get(ctx context.Context, desc Description) (io.ReadCloser, Info, error) {
+	b := s.blob(desc)
+	props, err := b.GetProperties(ctx, nil)
+	if err != nil {
+		return nil, Info{}, azblobError(err)
+	}
+
+	info := azblobInfo(props)
+	return &rangeReader{
+		open: func(off int64) (io.ReadCloser, error) {
+			res, err := b.DownloadStream(ctx, &blob.DownloadStreamOptions{
+				Range: blob.HTTPRange{Offset: off},
+				// Seeking does not mix up the contents if the blob is replaced.
+				AccessConditions: &blob.AccessConditions{
+					ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: props.ETag},
+				},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("download blob from %d: %w", off, azblobError(err))
+			}
+
+			return res.Body, nil
+		},
+		size: info.Size,
+	}, info, nil
+}
+
+// Head is served from the properties of the blob.
+func (s *azblobStore) Head(ctx context.Context, desc Description) (Info, error) {
+	props, err := s.blob(desc).GetProperties(ctx, nil)
+	if err != nil {
+		return Info{}, azblobError(err)
+	}
+
+	return azblobInfo(props), nil
+}
+
+// ReadAt reads the part of the blob by a ranged download.
+func (s *azblobStore) ReadAt(ctx context.Context, desc Description, p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	res, err := s.blob(desc).DownloadStream(ctx, &blob.DownloadStreamOptions{
+		Range: blob.HTTPRange{Offset: off, Count: int64(len(p))},
+	})
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.InvalidRange) {
+			// The blob ends before the offset.
+			return 0, io.EOF
+		}
+		return 0, azblobError(err)
+	}
+	defer res.Body.Close()
+
+	n, err := io.ReadFull(res.Body, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// Put stages the artifact by blocks as it is read and commits them at the end,
+// only if the blob still does not exist so concurrent uploads do not overwrite each other.
+func (s *azblobStore) Put(ctx context.Context, desc Description, r io.Reader) error {
+	b := s.blob(desc)
+	if _, err := b.GetProperties(ctx, nil); err == nil {
+		return ErrExist
+	} else if err := azblobError(err); !errors.Is(err, ErrNotExist) {
+		return fmt.Errorf("get blob properties: %w", err)
+	}
+
+	_, err := b.UploadStream(ctx, r, &blockblob.UploadStreamOptions{
+		BlockSize: s.block_size,
+		HTTPHeaders: &blob.HTTPHeaders{
+			BlobContentType: to.Ptr("application/zip"),
+		},
+		AccessConditions: &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{
+				IfNoneMatch: to.Ptr(azcore.ETagAny),
+			},
+		},
+	})
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobAlreadyExists, bloberror.ConditionNotMet) {
+			return ErrExist
+		}
+		return fmt.Errorf("upload blob: %w", err)
+	}
+
+	return nil
+}
+
+func (s *azblobStore) Delete(ctx context.Context, desc Description) error {
+	if _, err := s.blob(desc).Delete(ctx, nil); err != nil {
+		return azblobError(err)
+	}
+
+	return nil
+}
+
+func (s *azblobStore) List(ctx context.Context, fn func(entry Entry) error) error {
+	prefix := objectListPrefix(s.prefix)
+
+	pager := s.client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix: &prefix,
+	})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("list blobs: %w", err)
+		}
+
+		for _, item := range page.Segment.BlobItems {
+			if item.Name == nil || item.Properties == nil {
+				continue
+			}
+
+			desc, ok := unresolveObjectKey(prefix, *item.Name)
+			if !ok {
+				continue
+			}
+
+			entry := Entry{Description: desc}
+			if item.Properties.ContentLength != nil {
+				entry.Size = *item.Properties.ContentLength
+			}
+			if item.Properties.LastModified != nil {
+				entry.ModTime = *item.Properties.LastModified
+			}
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *azblobStore) Close() error {
+	return nil
+}
@@ -0,0 +1,178 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	main "github.com/lesomnus/vcpkg-cache-http"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// Well-known account of Azurite.
+const (
+	azuriteAccount    = "devstoreaccount1"
+	azuriteAccountKey = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+)
+
+// azuriteEndpoint returns the blob endpoint of Azurite given by `AZURITE_BLOB_ENDPOINT`,
+// e.g. "http://127.0.0.1:10000/devstoreaccount1", or skips the test if it is not given.
+func azuriteEndpoint(t *testing.T) string {
+	endpoint := os.Getenv("AZURITE_BLOB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("AZURITE_BLOB_ENDPOINT is not set")
+	}
+
+	return strings.TrimSuffix(endpoint, "/")
+}
+
+// NewTestAzblobContainer creates a container on Azurite that is deleted at the end of the test.
+func NewTestAzblobContainer(t *testing.T) (*container.Client, string) {
+	require := require.New(t)
+
+	endpoint := azuriteEndpoint(t)
+	name := fmt.Sprintf("vcpkg-%d", time.Now().UnixNano())
+
+	cred, err := container.NewSharedKeyCredential(azuriteAccount, azuriteAccountKey)
+	require.NoError(err)
+
+	client, err := container.NewClientWithSharedKeyCredential(endpoint+"/"+name, cred, nil)
+	require.NoError(err)
+
+	_, err = client.Create(context.Background(), nil)
+	require.NoError(err)
+	t.Cleanup(func() {
+		client.Delete(context.Background(), nil)
+	})
+
+	return client, name
+}
+
+type AzblobStoreSetup struct{}
+
+func (s *AzblobStoreSetup) New(t *testing.T) (main.Store, error) {
+	client, _ := NewTestAzblobContainer(t)
+	return main.NewAzblobStore(client, main.WithAzblobPrefix("cache"))
+}
+
+func TestAzblobStoreSuite(t *testing.T) {
+	azuriteEndpoint(t)
+	suite.Run(t, &StoreTestSuite{Store: &AzblobStoreSetup{}})
+}
+
+func TestNewAzblobStore(t *testing.T) {
+	// Responds as Azure Blob Storage without any container.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-error-code", "ContainerNotFound")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	t.Run("container must exist", func(t *testing.T) {
+		require := require.New(t)
+
+		client, err := container.NewClientWithNoCredential(server.URL+"/"+azuriteAccount+"/vcpkg", nil)
+		require.NoError(err)
+
+		_, err = main.NewAzblobStore(client)
+		require.ErrorContains(err, "container not found")
+	})
+
+	t.Run("block size must be positive", func(t *testing.T) {
+		require := require.New(t)
+
+		client, err := container.NewClientWithNoCredential(server.URL+"/"+azuriteAccount+"/vcpkg", nil)
+		require.NoError(err)
+
+		_, err = main.NewAzblobStore(client, main.WithAzblobBlockSize(0))
+		require.ErrorContains(err, "block size")
+	})
+}
+
+func TestAzblobStore(t *testing.T) {
+	t.Run("large artifact is uploaded by blocks", func(t *testing.T) {
+		require := require.New(t)
+
+		client, _ := NewTestAzblobContainer(t)
+		store, err := main.NewAzblobStore(client, main.WithAzblobBlockSize(256*1024))
+		require.NoError(err)
+
+		data := bytes.Repeat(randomData(t), 5000)
+
+		ctx := context.Background()
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		received, err := readAll(ctx, store, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(data, received)
+
+		info, err := store.Head(ctx, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(int64(len(data)), info.Size)
+	})
+
+	t.Run("artifact is read from the offset", func(t *testing.T) {
+		require := require.New(t)
+
+		client, _ := NewTestAzblobContainer(t)
+		store, err := main.NewAzblobStore(client)
+		require.NoError(err)
+
+		data := randomData(t)
+
+		ctx := context.Background()
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		r, _, err := store.Get(ctx, DescriptionFoo)
+		require.NoError(err)
+		defer r.Close()
+
+		seeker, ok := r.(io.ReadSeeker)
+		require.True(ok)
+
+		_, err = seeker.Seek(-28, io.SeekEnd)
+		require.NoError(err)
+
+		received, err := io.ReadAll(seeker)
+		require.NoError(err)
+		require.Equal(data[100:], received)
+	})
+
+	t.Run("blobs not in the layout are not listed", func(t *testing.T) {
+		require := require.New(t)
+
+		client, _ := NewTestAzblobContainer(t)
+		store, err := main.NewAzblobStore(client, main.WithAzblobPrefix("cache"))
+		require.NoError(err)
+
+		ctx := context.Background()
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		for _, name := range []string{"cache/foo", "other/foo/bar/baz"} {
+			_, err := client.NewBlockBlobClient(name).UploadBuffer(ctx, []byte("foo"), nil)
+			require.NoError(err)
+		}
+
+		entries := []main.Entry{}
+		err = store.List(ctx, func(entry main.Entry) error {
+			entries = append(entries, entry)
+			return nil
+		})
+		require.NoError(err)
+		require.Len(entries, 1)
+		require.Equal(DescriptionFoo, entries[0].Description)
+		require.Equal(int64(128), entries[0].Size)
+	})
+}
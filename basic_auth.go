@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func parseRole(s string) (Permission, error) {
+	switch s {
+	case "none":
+		return PermNone, nil
+	case "read", "r":
+		return PermRead, nil
+	case "write", "w":
+		return PermWrite, nil
+	case "delete", "d":
+		return PermDelete, nil
+	case "read-write", "rw":
+		return PermRead | PermWrite, nil
+	case "read-write-delete", "rwd":
+		return PermRead | PermWrite | PermDelete, nil
+	default:
+		return PermNone, fmt.Errorf("invalid role %q", s)
+	}
+}
+
+// parseHtpasswd parses htpasswd file which has "user:hash" for each line.
+// Only bcrypt hashes are supported.
+func parseHtpasswd(data []byte) (map[string][]byte, error) {
+	users := map[string][]byte{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("line %d: invalid entry", i)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("line %d: only bcrypt is supported: %w", i, err)
+		}
+
+		users[user] = []byte(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// basicAuth authenticates the client by HTTP Basic authentication against htpasswd file.
+// The file is reloaded when it is modified.
+type basicAuth struct {
+	path         string
+	roles        map[string]Permission
+	default_role Permission
+
+	mutex    sync.Mutex
+	mod_time time.Time
+	size     int64
+	users    map[string][]byte
+
+	// Digests of the passwords verified with bcrypt hashes
+	// so that bcrypt, which is slow by design, does not run for every request.
+	verified map[string][sha256.Size]byte
+}
+
+func newBasicAuth(conf *AuthConfig) (*basicAuth, error) {
+	a := &basicAuth{
+		path:  conf.Htpasswd,
+		roles: map[string]Permission{},
+	}
+
+	for user, role := range conf.Roles {
+		perm, err := parseRole(role)
+		if err != nil {
+			return nil, fmt.Errorf("role of %s: %w", user, err)
+		}
+
+		a.roles[user] = perm
+	}
+
+	if conf.DefaultRole != "" {
+		perm, err := parseRole(conf.DefaultRole)
+		if err != nil {
+			return nil, fmt.Errorf("default role: %w", err)
+		}
+
+		a.default_role = perm
+	}
+
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// reload reads htpasswd file if it is modified since the last load.
+func (a *basicAuth) reload() error {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return fmt.Errorf("stat htpasswd file: %w", err)
+	}
+	if a.users != nil && info.ModTime().Equal(a.mod_time) && info.Size() == a.size {
+		return nil
+	}
+
+	data, err := os.ReadFile(a.path)
+	if err != nil {
+		return fmt.Errorf("read htpasswd file: %w", err)
+	}
+
+	users, err := parseHtpasswd(data)
+	if err != nil {
+		return fmt.Errorf("parse htpasswd file: %w", err)
+	}
+
+	a.mod_time = info.ModTime()
+	a.size = info.Size()
+	a.users = users
+	a.verified = map[string][sha256.Size]byte{}
+
+	return nil
+}
+
+func (a *basicAuth) Authenticate(req *http.Request) (Client, error) {
+	user, pass, ok := req.BasicAuth()
+	if !ok {
+		return Client{}, ErrUnauthorized
+	}
+
+	a.mutex.Lock()
+	if err := a.reload(); err != nil {
+		// Keeps using the users loaded previously.
+		zerolog.Ctx(req.Context()).Error().Err(err).Msg("failed to reload htpasswd file")
+	}
+
+	hash, ok := a.users[user]
+	verified, is_verified := a.verified[user]
+	a.mutex.Unlock()
+	if !ok {
+		return Client{}, ErrUnauthorized
+	}
+
+	digest := sha256.Sum256([]byte(pass))
+	if !is_verified || subtle.ConstantTimeCompare(digest[:], verified[:]) != 1 {
+		if err := bcrypt.CompareHashAndPassword(hash, []byte(pass)); err != nil {
+			return Client{}, ErrUnauthorized
+		}
+
+		a.mutex.Lock()
+		if bytes.Equal(a.users[user], hash) {
+			a.verified[user] = digest
+		}
+		a.mutex.Unlock()
+	}
+
+	perm, ok := a.roles[user]
+	if !ok {
+		perm = a.default_role
+	}
+
+	return Client{Name: user, Permission: perm}, nil
+}
+
+func (a *basicAuth) Challenges() []string {
+	return []string{`Basic realm="vcpkg-cache-http", charset="UTF-8"`}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// Metadata of the artifacts keyed by `Description.String()`.
+	boltStoreEntriesBucket = []byte("entries")
+
+	// Content of the artifacts; a nested bucket of chunks for each upload.
+	boltStoreDataBucket = []byte("data")
+)
+
+// Size of the chunks an artifact is split into.
+// Each chunk is written in its own transaction so an upload does not hold the whole artifact in memory.
+const boltStoreChunkSize = 4 << 20
+
+// Number of entries read in a transaction while listing.
+const boltStoreListBatchSize = 1024
+
+type boltMeta struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Hash    string `json:"hash"`
+
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	Digest   string    `json:"digest"`
+	Uploader string    `json:"uploader,omitempty"`
+
+	// ID of the nested bucket in the data bucket and the size of its chunks.
+	Data      uint64 `json:"data"`
+	ChunkSize int64  `json:"chunk_size"`
+}
+
+func (m *boltMeta) description() Description {
+	return Description{Name: m.Name, Version: m.Version, Hash: m.Hash}
+}
+
+func (m *boltMeta) info() Info {
+	return Info{Size: m.Size, ModTime: m.ModTime, Digest: m.Digest, Seekable: true}
+}
+
+func boltStoreKey(id uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, id)
+}
+
+// boltStore keeps artifacts and their metadata in a single bbolt database file.
+// An artifact becomes visible only when its metadata is committed after all of its chunks.
+type boltStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStore(p string) (*boltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(p), 0744); err != nil {
+		return nil, fmt.Errorf("create database directory: %w", err)
+	}
+
+	db, err := bolt.Open(p, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+
+	s := &boltStore{db: db}
+	if err := s.init(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// init creates the buckets and removes the chunks of the uploads interrupted by a crash.
+func (s *boltStore) init() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		entries, err := tx.CreateBucketIfNotExists(boltStoreEntriesBucket)
+		if err != nil {
+			return fmt.Errorf("create entries bucket: %w", err)
+		}
+		data, err := tx.CreateBucketIfNotExists(boltStoreDataBucket)
+		if err != nil {
+			return fmt.Errorf("create data bucket: %w", err)
+		}
+
+		used := map[uint64]bool{}
+		err = entries.ForEach(func(k, v []byte) error {
+			meta := boltMeta{}
+			if err := json.Unmarshal(v, &meta); err != nil {
+				return fmt.Errorf("decode metadata of %s: %w", k, err)
+			}
+
+			used[meta.Data] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		orphans := [][]byte{}
+		err = data.ForEachBucket(func(k []byte) error {
+			if !used[binary.BigEndian.Uint64(k)] {
+				orphans = append(orphans, bytes.Clone(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range orphans {
+			if err := data.DeleteBucket(k); err != nil {
+				return fmt.Errorf("delete incomplete upload: %w", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *boltStore) meta(desc Description) (boltMeta, error) {
+	meta := boltMeta{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltStoreEntriesBucket).Get([]byte(desc.String()))
+		if v == nil {
+			return ErrNotExist
+		}
+
+		return json.Unmarshal(v, &meta)
+	})
+
+	return meta, err
+}
+
+func (s *boltStore) Get(ctx context.Context, desc Description) (io.ReadCloser, Info, error) {
+	meta, err := s.meta(desc)
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	return &boltReader{db: s.db, meta: meta}, meta.info(), nil
+}
+
+func (s *boltStore) Head(ctx context.Context, desc Description) (Info, error) {
+	meta, err := s.meta(desc)
+	if err != nil {
+		return Info{}, err
+	}
+
+	return meta.info(), nil
+}
+
+func (s *boltStore) Put(ctx context.Context, desc Description, r io.Reader) error {
+	key := []byte(desc.String())
+
+	var id uint64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltStoreEntriesBucket).Get(key) != nil {
+			return ErrExist
+		}
+
+		data := tx.Bucket(boltStoreDataBucket)
+		v, err := data.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		id = v
+		_, err = data.CreateBucket(boltStoreKey(id))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	meta, err := s.write(ctx, id, r)
+	if err == nil {
+		meta.Name = desc.Name
+		meta.Version = desc.Version
+		meta.Hash = desc.Hash
+		if client, ok := ClientFromContext(ctx); ok {
+			meta.Uploader = client.Name
+		}
+
+		err = s.db.Update(func(tx *bolt.Tx) error {
+			entries := tx.Bucket(boltStoreEntriesBucket)
+			if entries.Get(key) != nil {
+				// Stored by another request while it is being written.
+				return ErrExist
+			}
+
+			v, err := json.Marshal(meta)
+			if err != nil {
+				return err
+			}
+
+			return entries.Put(key, v)
+		})
+	}
+	if err != nil {
+		s.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(boltStoreDataBucket).DeleteBucket(boltStoreKey(id))
+		})
+		return err
+	}
+
+	return nil
+}
+
+// write writes content of `r` into the data bucket of `id` by chunks.
+func (s *boltStore) write(ctx context.Context, id uint64, r io.Reader) (boltMeta, error) {
+	h := sha256.New()
+	buff := make([]byte, boltStoreChunkSize)
+
+	size := int64(0)
+	for i := uint64(0); ; i++ {
+		n, err := io.ReadFull(r, buff)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return boltMeta{}, err
+		}
+		if err := ctx.Err(); err != nil {
+			return boltMeta{}, err
+		}
+
+		chunk := buff[:n]
+		h.Write(chunk)
+		size += int64(n)
+
+		err_put := s.db.Update(func(tx *bolt.Tx) error {
+			data := tx.Bucket(boltStoreDataBucket).Bucket(boltStoreKey(id))
+			if data == nil {
+				return errors.New("data bucket is removed while writing")
+			}
+
+			return data.Put(boltStoreKey(i), chunk)
+		})
+		if err_put != nil {
+			return boltMeta{}, fmt.Errorf("write chunk: %w", err_put)
+		}
+		if err != nil {
+			// Last chunk is read.
+			break
+		}
+	}
+
+	return boltMeta{
+		Size:      size,
+		ModTime:   time.Now(),
+		Digest:    hex.EncodeToString(h.Sum(nil)),
+		Data:      id,
+		ChunkSize: boltStoreChunkSize,
+	}, nil
+}
+
+// List lists entries in order of their keys.
+// Entries are read by batches so `fn` is not called in a transaction.
+func (s *boltStore) List(ctx context.Context, fn func(entry Entry) error) error {
+	var last []byte
+	for {
+		entries := []Entry{}
+		err := s.db.View(func(tx *bolt.Tx) error {
+			c := tx.Bucket(boltStoreEntriesBucket).Cursor()
+
+			k, v := c.First()
+			if last != nil {
+				k, v = c.Seek(last)
+				if bytes.Equal(k, last) {
+					k, v = c.Next()
+				}
+			}
+			for ; k != nil && len(entries) < boltStoreListBatchSize; k, v = c.Next() {
+				meta := boltMeta{}
+				if err := json.Unmarshal(v, &meta); err != nil {
+					return fmt.Errorf("decode metadata of %s: %w", k, err)
+				}
+
+				entries = append(entries, Entry{
+					Description: meta.description(),
+					Size:        meta.Size,
+					ModTime:     meta.ModTime,
+					Digest:      meta.Digest,
+					Uploader:    meta.Uploader,
+				})
+				last = bytes.Clone(k)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		if len(entries) < boltStoreListBatchSize {
+			return nil
+		}
+	}
+}
+
+func (s *boltStore) Delete(ctx context.Context, desc Description) error {
+	key := []byte(desc.String())
+	return s.db.Update(func(tx *bolt.Tx) error {
+		entries := tx.Bucket(boltStoreEntriesBucket)
+		v := entries.Get(key)
+		if v == nil {
+			return ErrNotExist
+		}
+
+		meta := boltMeta{}
+		if err := json.Unmarshal(v, &meta); err != nil {
+			return fmt.Errorf("decode metadata: %w", err)
+		}
+		if err := entries.Delete(key); err != nil {
+			return err
+		}
+
+		err := tx.Bucket(boltStoreDataBucket).DeleteBucket(boltStoreKey(meta.Data))
+		if err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+// boltReader reads chunks of an artifact each in its own transaction
+// so a slow client does not keep a transaction open.
+type boltReader struct {
+	db   *bolt.DB
+	meta boltMeta
+	off  int64
+}
+
+func (r *boltReader) Read(p []byte) (int, error) {
+	if r.off >= r.meta.Size {
+		return 0, io.EOF
+	}
+
+	i := r.off / r.meta.ChunkSize
+	n := 0
+	err := r.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltStoreDataBucket).Bucket(boltStoreKey(r.meta.Data))
+		if data == nil {
+			return fmt.Errorf("artifact is deleted while reading: %w", ErrNotExist)
+		}
+
+		chunk := data.Get(boltStoreKey(uint64(i)))
+		if chunk == nil {
+			return fmt.Errorf("chunk %d: %w", i, ErrCorrupted)
+		}
+
+		offset := r.off - i*r.meta.ChunkSize
+		if offset >= int64(len(chunk)) {
+			return fmt.Errorf("chunk %d is shorter than expected: %w", i, ErrCorrupted)
+		}
+
+		n = copy(p, chunk[offset:])
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	r.off += int64(n)
+	return n, nil
+}
+
+func (r *boltReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.meta.Size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	r.off = offset
+	return offset, nil
+}
+
+func (r *boltReader) Close() error {
+	return nil
+}
@@ -0,0 +1,215 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"testing/iotest"
+
+	main "github.com/lesomnus/vcpkg-cache-http"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	bolt "go.etcd.io/bbolt"
+)
+
+func NewTestBoltStore(t *testing.T) (main.Store, error) {
+	store, err := main.NewBoltStore(filepath.Join(t.TempDir(), "vcpkg-cache.db"))
+	if err != nil {
+		return nil, err
+	}
+
+	t.Cleanup(func() { store.Close() })
+	return store, nil
+}
+
+type BoltStoreSetup struct{}
+
+func (s *BoltStoreSetup) New(t *testing.T) (main.Store, error) {
+	return NewTestBoltStore(t)
+}
+
+func TestBoltStoreSuite(t *testing.T) {
+	suite.Run(t, &StoreTestSuite{Store: &BoltStoreSetup{}})
+}
+
+func TestBoltStore(t *testing.T) {
+	t.Run("artifacts persist across reopen", func(t *testing.T) {
+		require := require.New(t)
+
+		p := filepath.Join(t.TempDir(), "vcpkg-cache.db")
+		store, err := main.NewBoltStore(p)
+		require.NoError(err)
+
+		// Spans multiple chunks.
+		data := make([]byte, 9<<20)
+		_, err = rand.Read(data)
+		require.NoError(err)
+
+		ctx := context.Background()
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+		require.NoError(store.Close())
+
+		store, err = main.NewBoltStore(p)
+		require.NoError(err)
+		defer store.Close()
+
+		received, err := readAll(ctx, store, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(data, received)
+
+		// Read across the boundary of the chunks.
+		r, _, err := store.Get(ctx, DescriptionFoo)
+		require.NoError(err)
+		defer r.Close()
+
+		seeker, ok := r.(io.ReadSeeker)
+		require.True(ok)
+
+		_, err = seeker.Seek((4<<20)-3, io.SeekStart)
+		require.NoError(err)
+
+		received = make([]byte, 6)
+		_, err = io.ReadFull(seeker, received)
+		require.NoError(err)
+		require.Equal(data[(4<<20)-3:(4<<20)+3], received)
+	})
+
+	t.Run("database cannot be opened twice", func(t *testing.T) {
+		require := require.New(t)
+
+		p := filepath.Join(t.TempDir(), "vcpkg-cache.db")
+		store, err := main.NewBoltStore(p)
+		require.NoError(err)
+		defer store.Close()
+
+		_, err = main.NewBoltStore(p)
+		require.ErrorContains(err, "open database")
+	})
+
+	t.Run("failed upload is not stored", func(t *testing.T) {
+		require := require.New(t)
+
+		store, err := NewTestBoltStore(t)
+		require.NoError(err)
+
+		ctx := context.Background()
+		r := io.MultiReader(bytes.NewReader(randomData(t)), iotest.ErrReader(errors.New("broken")))
+		err = store.Put(ctx, DescriptionFoo, r)
+		require.ErrorContains(err, "broken")
+
+		_, err = store.Head(ctx, DescriptionFoo)
+		require.ErrorIs(err, main.ErrNotExist)
+
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+	})
+
+	t.Run("chunks of interrupted uploads are removed on open", func(t *testing.T) {
+		require := require.New(t)
+
+		p := filepath.Join(t.TempDir(), "vcpkg-cache.db")
+		store, err := main.NewBoltStore(p)
+		require.NoError(err)
+		require.NoError(store.Close())
+
+		db, err := bolt.Open(p, 0644, nil)
+		require.NoError(err)
+		err = db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.Bucket([]byte("data")).CreateBucket([]byte{0, 0, 0, 0, 0, 0, 0, 42})
+			return err
+		})
+		require.NoError(err)
+		require.NoError(db.Close())
+
+		store, err = main.NewBoltStore(p)
+		require.NoError(err)
+		require.NoError(store.Close())
+
+		db, err = bolt.Open(p, 0644, nil)
+		require.NoError(err)
+		defer db.Close()
+		err = db.View(func(tx *bolt.Tx) error {
+			require.Nil(tx.Bucket([]byte("data")).Bucket([]byte{0, 0, 0, 0, 0, 0, 0, 42}))
+			return nil
+		})
+		require.NoError(err)
+	})
+
+	t.Run("entries more than a batch are listed", func(t *testing.T) {
+		require := require.New(t)
+
+		store, err := NewTestBoltStore(t)
+		require.NoError(err)
+
+		ctx := context.Background()
+		descs := map[main.Description]bool{}
+		for i := 0; i < 1030; i++ {
+			desc := main.Description{Name: "foo", Version: "bar", Hash: strconv.Itoa(i)}
+			err := store.Put(ctx, desc, bytes.NewReader([]byte("foo")))
+			require.NoError(err)
+
+			descs[desc] = true
+		}
+
+		listed := map[main.Description]bool{}
+		err = store.(main.Lister).List(ctx, func(entry main.Entry) error {
+			require.False(listed[entry.Description])
+			listed[entry.Description] = true
+			return nil
+		})
+		require.NoError(err)
+		require.Equal(descs, listed)
+	})
+
+	t.Run("entries are listed with metadata", func(t *testing.T) {
+		require := require.New(t)
+
+		store, err := NewTestBoltStore(t)
+		require.NoError(err)
+
+		auth, err := main.NewAuthenticator(&main.AuthConfig{
+			WriteTokens: map[string]string{"ci": "s3cret"},
+		})
+		require.NoError(err)
+
+		handler := &main.Handler{
+			Store: store,
+			Log:   zerolog.New(io.Discard),
+			Auth:  auth,
+
+			IsWritable: true,
+		}
+
+		data := randomData(t)
+		req := httptest.NewRequest(http.MethodPut, DescriptionFoo.String(), bytes.NewReader(data))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(http.StatusOK, w.Result().StatusCode)
+
+		ctx := context.Background()
+		info, err := store.Head(ctx, DescriptionFoo)
+		require.NoError(err)
+
+		entries := []main.Entry{}
+		err = store.(main.Lister).List(ctx, func(entry main.Entry) error {
+			entries = append(entries, entry)
+			return nil
+		})
+		require.NoError(err)
+		require.Len(entries, 1)
+		require.Equal(DescriptionFoo, entries[0].Description)
+		require.Equal(int64(len(data)), entries[0].Size)
+		require.Equal(info.Digest, entries[0].Digest)
+		require.Equal("ci", entries[0].Uploader)
+	})
+}
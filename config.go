@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mattn/go-isatty"
 )
@@ -16,19 +18,63 @@ type AppConfig struct {
 	Host string `json:"host"`
 	Port uint   `json:"port"`
 
+	TlsCert     string `json:"tls_cert,omitempty"`
+	TlsKey      string `json:"tls_key,omitempty"`
+	TlsClientCa string `json:"tls_client_ca,omitempty"`
+
 	Store *StoreConfig `json:"store,omitempty"`
 
 	NoColor bool `json:"no_color"`
 	LogJson bool `json:"log_json"`
+	Metrics bool `json:"metrics"`
+
+	// Minimum interval between enumerations of the store for the metrics of the store.
+	// The metrics of the store are not collected if 0.
+	MetricsStoreInterval Duration `json:"metrics_store_interval,omitempty"`
+
+	ReadOnly    bool `json:"read_only"`
+	WriteOnly   bool `json:"write_only"`
+	AllowDelete bool `json:"allow_delete"`
+
+	ValidateArchives bool `json:"validate_archives"`
+
+	// Directory where uploads are spooled to be validated; the temporary directory of the system if empty.
+	SpoolDir string `json:"spool_dir,omitempty"`
+
+	Retention *RetentionConfig `json:"retention,omitempty"`
+
+	Auth *AuthConfig `json:"auth,omitempty"`
+}
+
+// Duration is a `time.Duration` represented as a string such as "14d" or "12h" in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+
+	v, err := parseDuration(s)
+	if err != nil {
+		return err
+	}
 
-	ReadOnly  bool `json:"read_only"`
-	WriteOnly bool `json:"write_only"`
+	*d = Duration(v)
+	return nil
 }
 
 type StoreConfig struct {
 	Kind string            `json:"kind"`
 	Path string            `json:"path"`
 	Opts map[string]string `json:"opts"`
+
+	// Stores composed by "tiered" store.
+	Tiers []*StoreConfig `json:"tiers,omitempty"`
 }
 
 func (c *StoreConfig) String() string {
@@ -36,6 +82,8 @@ func (c *StoreConfig) String() string {
 	for k, v := range c.Opts {
 		if v == "" {
 			rst += fmt.Sprintf(",%s", k)
+		} else if strings.Contains(v, ",") {
+			rst += fmt.Sprintf(",%s=(%s)", k, v)
 		} else {
 			rst += fmt.Sprintf(",%s=%s", k, v)
 		}
@@ -44,6 +92,25 @@ func (c *StoreConfig) String() string {
 	return rst
 }
 
+// Bool reports whether the option of given key is set.
+// An option given without value, e.g. "kind:path,opt", is considered to be true.
+func (c *StoreConfig) Bool(key string) (bool, error) {
+	v, ok := c.Opts[key]
+	if !ok {
+		return false, nil
+	}
+	if v == "" {
+		return true, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid value for option %s: %w", key, err)
+	}
+
+	return b, nil
+}
+
 func ParseStoreConfig(s string) (*StoreConfig, error) {
 	entries := strings.SplitN(s, ":", 2)
 	if entries[0] == "" {
@@ -62,7 +129,10 @@ func ParseStoreConfig(s string) (*StoreConfig, error) {
 	}
 
 	kind := entries[0]
-	opts := strings.Split(entries[1], ",")
+	opts, err := splitSpec(entries[1], ',')
+	if err != nil {
+		return nil, err
+	}
 
 	conf := &StoreConfig{
 		Kind: kind,
@@ -78,7 +148,7 @@ func ParseStoreConfig(s string) (*StoreConfig, error) {
 
 		v := ""
 		if len(kv) == 2 {
-			v = kv[1]
+			v = ungroupSpec(kv[1])
 		}
 
 		conf.Opts[kv[0]] = v
@@ -87,10 +157,142 @@ func ParseStoreConfig(s string) (*StoreConfig, error) {
 	return conf, nil
 }
 
+// splitSpec splits the spec by `sep` except inside parentheses
+// so a nested store can be given with its options, e.g. "proxy:url,local=(files:cache,max_size=10GiB)".
+func splitSpec(s string, sep rune) ([]string, error) {
+	entries := []string{}
+	depth := 0
+	begin := 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses in %q", s)
+			}
+		case sep:
+			if depth == 0 {
+				entries = append(entries, s[begin:i])
+				begin = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses in %q", s)
+	}
+
+	return append(entries, s[begin:]), nil
+}
+
+// ungroupSpec removes the parentheses enclosing the whole spec.
+func ungroupSpec(s string) string {
+	if len(s) < 2 || s[0] != '(' || s[len(s)-1] != ')' {
+		return s
+	}
+
+	// Parentheses such as "(a)|(b)" do not enclose the whole spec.
+	depth := 0
+	for _, c := range s[:len(s)-1] {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		if depth == 0 {
+			return s
+		}
+	}
+
+	return s[1 : len(s)-1]
+}
+
 func NewStore(conf *StoreConfig) (Store, error) {
+	store, err := newStore(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err = wrapStore(store, conf)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// wrapStore applies options that can be given to any kind of store.
+// Artifacts are compressed before they are encrypted.
+func wrapStore(store Store, conf *StoreConfig) (Store, error) {
+	// Uploads are spooled to the work directory of the store by default
+	// so they are written to the same disk rather than the temporary directory, which can be a small tmpfs.
+	spool_dir, ok := conf.Opts["spool_dir"]
+	if fs_store, is_fs := store.(*fsStore); !ok && is_fs {
+		spool_dir = fs_store.work
+	}
+
+	keys, err := cryptKeysFromConfig(conf)
+	if err != nil {
+		return store, err
+	}
+	if keys != nil {
+		store = NewCryptStore(store, keys)
+	}
+
+	if v, ok := conf.Opts["compress"]; ok {
+		switch v {
+		case "zstd":
+			store = NewZstdStore(store, WithZstdSpoolDir(spool_dir))
+		default:
+			return store, fmt.Errorf("invalid value for option compress: %s", v)
+		}
+	}
+
+	return store, nil
+}
+
+func cryptKeysFromConfig(conf *StoreConfig) (*CryptKeys, error) {
+	p, has_file := conf.Opts["encrypt_key_file"]
+	name, has_env := conf.Opts["encrypt_key_env"]
+	switch {
+	case has_file && has_env:
+		return nil, errors.New("only one of encrypt_key_file and encrypt_key_env can be given")
+
+	case has_file:
+		keys, err := LoadCryptKeys(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for option encrypt_key_file: %w", err)
+		}
+		return keys, nil
+
+	case has_env:
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("invalid value for option encrypt_key_env: environment variable %s is not set", name)
+		}
+
+		keys, err := ParseCryptKeys(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for option encrypt_key_env: %w", err)
+		}
+		return keys, nil
+	}
+
+	return nil, nil
+}
+
+func newStore(conf *StoreConfig) (Store, error) {
 	switch conf.Kind {
 	case "files":
-		return NewFsStore(conf.Path)
+		opts, err := fsOptionsFromConfig(conf)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewFsStore(conf.Path, opts...)
 
 	case "archives":
 		p := conf.Path
@@ -102,15 +304,175 @@ func NewStore(conf *StoreConfig) (Store, error) {
 
 			p = filepath.Join(home, ".cache", "vcpkg", "archives")
 		}
-		return NewFsStore(p, WithPathResolve(func(desc Description) string {
-			return filepath.Join(desc.Hash[0:2], desc.Hash+".zip")
-		}))
+
+		opts, err := fsOptionsFromConfig(conf)
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts,
+			WithPathResolve(func(desc Description) string {
+				return filepath.Join(desc.Hash[0:2], desc.Hash+".zip")
+			}),
+			// Name and version are unknown from the layout.
+			WithPathUnresolve(func(key string) (Description, bool) {
+				dir, file := filepath.Split(key)
+				hash := strings.TrimSuffix(file, ".zip")
+				if len(hash) < 2 || hash == file || filepath.Clean(dir) != hash[0:2] {
+					return Description{}, false
+				}
+
+				return Description{Hash: hash}, true
+			}),
+		)
+		return NewFsStore(p, opts...)
+
+	case "memory":
+		opts := []memoryOption{}
+		if v, ok := conf.Opts["max_size"]; ok {
+			size, err := parseSize(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for option max_size: %w", err)
+			}
+
+			opts = append(opts, WithMemoryMaxSize(size))
+		}
+
+		return NewMemoryStore(opts...)
+
+	case "bolt":
+		p := conf.Path
+		if p == "" {
+			p = "vcpkg-cache.db"
+		}
+
+		return NewBoltStore(p)
+
+	case "s3":
+		return newS3StoreFromConfig(conf)
+
+	case "gcs":
+		return newGcsStoreFromConfig(conf)
+
+	case "azblob":
+		return newAzblobStoreFromConfig(conf)
+
+	case "proxy":
+		local_spec, ok := conf.Opts["local"]
+		if !ok {
+			local_spec = "files:vcpkg-cache"
+		}
+
+		local_conf, err := ParseStoreConfig(ungroupSpec(local_spec))
+		if err != nil {
+			return nil, fmt.Errorf("parse local store config: %w", err)
+		}
+
+		local, err := NewStore(local_conf)
+		if err != nil {
+			return nil, fmt.Errorf("create local store: %w", err)
+		}
+
+		store, err := NewProxyStore(local, conf.Path)
+		if err != nil {
+			local.Close()
+			return nil, err
+		}
+
+		return store, nil
+
+	case "sftp":
+		return newSftpStoreFromConfig(conf)
+
+	case "oci":
+		return newOciStoreFromConfig(conf)
+
+	case "http":
+		return newHttpStoreFromConfig(conf)
+
+	case "tiered":
+		return newTieredStoreFromConfig(conf)
 
 	default:
 		return nil, fmt.Errorf("kind not supported: %s", conf.Kind)
 	}
 }
 
+// newTieredStoreFromConfig creates tiers from `Tiers` of the config,
+// or from the path in the form of "kind:path|kind:path|..." if `Tiers` is not given.
+// A tier with options is enclosed in parentheses, e.g. "(memory:,max_size=1GiB)|files:cache".
+func newTieredStoreFromConfig(conf *StoreConfig) (Store, error) {
+	tier_confs := conf.Tiers
+	if len(tier_confs) == 0 && conf.Path != "" {
+		specs, err := splitSpec(conf.Path, '|')
+		if err != nil {
+			return nil, err
+		}
+
+		for _, spec := range specs {
+			tier_conf, err := ParseStoreConfig(ungroupSpec(spec))
+			if err != nil {
+				return nil, fmt.Errorf("parse tier config %q: %w", spec, err)
+			}
+
+			tier_confs = append(tier_confs, tier_conf)
+		}
+	}
+
+	opts := []tieredOption{}
+	if v, ok := conf.Opts["write"]; ok {
+		indices := []int{}
+		for _, s := range strings.Split(v, "|") {
+			i, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for option write: %w", err)
+			}
+
+			indices = append(indices, i)
+		}
+
+		opts = append(opts, WithWriteTiers(indices...))
+	}
+
+	tiers := []Store{}
+	closeTiers := func() {
+		for _, tier := range tiers {
+			tier.Close()
+		}
+	}
+	for i, tier_conf := range tier_confs {
+		tier, err := NewStore(tier_conf)
+		if err != nil {
+			closeTiers()
+			return nil, fmt.Errorf("create tier %d: %w", i, err)
+		}
+
+		tiers = append(tiers, tier)
+	}
+
+	store, err := NewTieredStore(tiers, opts...)
+	if err != nil {
+		closeTiers()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func fsOptionsFromConfig(conf *StoreConfig) ([]fsOption, error) {
+	opts := []fsOption{}
+	if v, ok := conf.Opts["max_size"]; ok {
+		size, err := parseSize(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for option max_size: %w", err)
+		}
+
+		opts = append(opts, WithMaxSize(size))
+	}
+
+	return opts, nil
+}
+
 func ParseArgs(args []string) (*AppConfig, error) {
 	var (
 		flags = flag.NewFlagSet(args[0], flag.ExitOnError)
@@ -129,14 +491,72 @@ Store:
 
     kind[:[path][,opt[=val]]]
 
+  A nested store, such as "local" of proxy or a tier of tiered, is
+  enclosed in parentheses to be given with its options, e.g.
+  "local=(files:vcpkg-cache,max_size=10GiB)".
+
   Available stores are:
     
-    files:[vcpkg-cache]
+    files:[vcpkg-cache][,max_size=size]
       Stores to a directory at the given path. This is a default store.
+      If "max_size" is given, e.g. "10GiB", least recently accessed
+      files are evicted when the total size exceeds it.
 
-    archives:[${HOME}/.cache/vcpkg/archives]
+    archives:[${HOME}/.cache/vcpkg/archives][,max_size=size]
       Use vcpkg "files" provider at the given path as a store.
 
+    memory:[,max_size=size]
+      Holds artifacts in memory. They are lost when the server stops.
+      Least recently accessed artifacts are evicted when the total size
+      exceeds "max_size", which is 1GiB by default; 0 for no limit.
+
+    bolt:[vcpkg-cache.db]
+      Stores artifacts and their metadata in a single bbolt database file.
+
+    s3:,bucket=name[,prefix=p][,region=r][,endpoint=url][,path_style][,part_size=size]
+      Stores to a bucket of S3-compatible object storage.
+      Uploads are streamed in parts of "part_size", 16MiB by default.
+      Credentials are read from "access_key" and "secret_key" options
+      or from AWS environment variables, credentials file or IAM role.
+
+    gcs:,bucket=name[,prefix=p][,credentials_file=key.json][,chunk_size=size]
+      Stores to a bucket of Google Cloud Storage.
+      Credentials are read from the service account key file if given,
+      otherwise from Application Default Credentials.
+
+    azblob:,account=name,container=c[,prefix=p][,endpoint=url][,block_size=size]
+      Stores to a container of Azure Blob Storage.
+      Credentials are read from "account_key" or "sas" options
+      or from AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN environment variables.
+
+    proxy:url[,local=files:vcpkg-cache]
+      Reads through the given upstream URL on a miss and persists fetched
+      artifacts to the local store.
+
+    sftp:user@host[:port][/path][,key_file=path][,known_hosts=path]
+      Stores to a directory of a remote host over SFTP.
+      Authenticates by the given private key or by SSH agent and
+      verifies the host by ~/.ssh/known_hosts unless "known_hosts" is given.
+
+    oci:registry/repository[,insecure][,username=u,password=p][,spool_dir=path]
+      Stores to a repository of an OCI registry, tagging each artifact
+      by its name, version and hash. Credentials are read from the Docker
+      config file unless "username" is given. The password can be read
+      from a file by "password_file" or from an environment variable
+      by "password_env" instead of "password". Uploads are spooled to
+      "spool_dir", or to the temporary directory if not given.
+
+    http:url[,dav][,username=u,password=p][,token=t]
+      Stores to an upstream HTTP server by PUT, GET, HEAD and DELETE,
+      such as Artifactory generic repositories or WebDAV servers.
+      The URL may contain {name}, {version} and {sha} placeholders.
+      If "dav" is given, parent collections are created by MKCOL.
+
+    tiered:kind:path|kind:path|...[,write=i|j]
+      Reads from the first tier that has the artifact and promotes it
+      into the preceding tiers. Uploads are written to all tiers or to
+      the tiers of the indices given by "write".
+
 `)
 
 		fmt.Println("Flags:")
@@ -146,10 +566,17 @@ Store:
 	flags.StringVar(&conf_path, "conf", "", "path to a config file")
 	flags.StringVar(&conf_given.Host, "host", "0.0.0.0", "host to listen")
 	flags.UintVar(&conf_given.Port, "port", uint(15151), "port to listen")
+	flags.StringVar(&conf_given.TlsCert, "tls-cert", "", "path to a certificate file to serve HTTPS")
+	flags.StringVar(&conf_given.TlsKey, "tls-key", "", "path to a private key file to serve HTTPS")
+	flags.StringVar(&conf_given.TlsClientCa, "tls-client-ca", "", "path to a CA certificate file to verify client certificates")
 	flags.BoolVar(&conf_given.NoColor, "no-color", !isatty.IsTerminal(os.Stdout.Fd()), "disable color print; set by default if output is not a terminal")
 	flags.BoolVar(&conf_given.LogJson, "log-json", false, "log in JSON format")
+	flags.BoolVar(&conf_given.Metrics, "metrics", false, "serve Prometheus metrics at /metrics")
 	flags.BoolVar(&conf_given.ReadOnly, "read-only", false, "enable read-only mode, restricting write operations")
 	flags.BoolVar(&conf_given.WriteOnly, "write-only", false, "enable write-only mode, restricting read operations")
+	flags.BoolVar(&conf_given.AllowDelete, "allow-delete", false, "allow DELETE requests to remove cached artifacts")
+	flags.BoolVar(&conf_given.ValidateArchives, "validate-archives", false, "reject uploads that are not package archives built by vcpkg")
+	flags.StringVar(&conf_given.SpoolDir, "spool-dir", "", "directory where uploads are spooled to be validated; the temporary directory if not given")
 	flags.Parse(args[1:])
 
 	switch flags.NArg() {
@@ -187,14 +614,28 @@ Store:
 			conf.Host = conf_given.Host
 		case "port":
 			conf.Port = conf_given.Port
+		case "tls-cert":
+			conf.TlsCert = conf_given.TlsCert
+		case "tls-key":
+			conf.TlsKey = conf_given.TlsKey
+		case "tls-client-ca":
+			conf.TlsClientCa = conf_given.TlsClientCa
 		case "no-color":
 			conf.NoColor = conf_given.NoColor
 		case "log-json":
 			conf.LogJson = conf_given.LogJson
+		case "metrics":
+			conf.Metrics = conf_given.Metrics
 		case "read-only":
 			conf.ReadOnly = conf_given.ReadOnly
 		case "write-only":
 			conf.WriteOnly = conf_given.WriteOnly
+		case "allow-delete":
+			conf.AllowDelete = conf_given.AllowDelete
+		case "validate-archives":
+			conf.ValidateArchives = conf_given.ValidateArchives
+		case "spool-dir":
+			conf.SpoolDir = conf_given.SpoolDir
 		}
 	})
 
@@ -210,6 +651,12 @@ func ParseArgsStrict(args []string) (*AppConfig, error) {
 	if conf.ReadOnly && conf.WriteOnly {
 		return nil, errors.New("read-only and write-only cannot be set together")
 	}
+	if conf.ReadOnly && conf.AllowDelete {
+		return nil, errors.New("read-only and allow-delete cannot be set together")
+	}
+	if conf.Store != nil && conf.Store.Kind == "archives" && conf.Retention != nil && len(conf.Retention.Overrides) > 0 {
+		return nil, fmt.Errorf("retention overrides cannot be applied to archives store: %w", ErrUnnamed)
+	}
 
 	return conf, nil
 }
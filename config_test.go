@@ -1,10 +1,15 @@
 package main_test
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	main "github.com/lesomnus/vcpkg-cache-http"
 	"github.com/mattn/go-isatty"
@@ -23,6 +28,7 @@ func TestStoreConfigString(t *testing.T) {
 		{Kind: "kind", Path: "path", Opts: opts_kv},
 		{Kind: "kind", Path: "", Opts: opts_k_only},
 		{Kind: "kind", Path: "path", Opts: opts_k_only},
+		{Kind: "kind", Path: "path", Opts: map[string]string{"local": "files:cache,max_size=1GiB"}},
 	}
 	for _, tc := range tcs {
 		conf, err := main.ParseStoreConfig(tc.String())
@@ -116,6 +122,27 @@ func TestParseBackendConfig(t *testing.T) {
 			given:    "kind:path,opt1=,=val3,opt2",
 			expected: case_key_only,
 		},
+		{
+			given: "kind:path,opt1=(nested:path,opt=val),opt2",
+			expected: main.StoreConfig{
+				Kind: "kind",
+				Path: "path",
+				Opts: map[string]string{
+					"opt1": "nested:path,opt=val",
+					"opt2": "",
+				},
+			},
+		},
+		{
+			given: "kind:(nested:a,opt=val)|(nested:b,opt=(c,d)),opt1=(a)|(b)",
+			expected: main.StoreConfig{
+				Kind: "kind",
+				Path: "(nested:a,opt=val)|(nested:b,opt=(c,d))",
+				Opts: map[string]string{
+					"opt1": "(a)|(b)",
+				},
+			},
+		},
 	}
 	for _, test_case := range test_cases {
 		actual, err := main.ParseStoreConfig(test_case.given)
@@ -148,6 +175,453 @@ func TestParseBackendConfigFail(t *testing.T) {
 			require.ErrorContains(err, "kind")
 		}
 	})
+
+	t.Run("parentheses must be balanced", func(t *testing.T) {
+		require := require.New(t)
+
+		for _, given := range []string{
+			"kind:path,opt=(nested:path,opt=val",
+			"kind:path,opt=nested:path),opt=val",
+			"kind:(path,opt",
+		} {
+			_, err := main.ParseStoreConfig(given)
+			require.ErrorContains(err, "parentheses", given)
+		}
+	})
+}
+
+func TestNewStore(t *testing.T) {
+	t.Run("files store with max size", func(t *testing.T) {
+		require := require.New(t)
+
+		for _, v := range []string{"1024", "10K", "10KB", "10KiB", "1.5GiB"} {
+			store, err := main.NewStore(&main.StoreConfig{
+				Kind: "files",
+				Path: t.TempDir(),
+				Opts: map[string]string{"max_size": v},
+			})
+			require.NoError(err, v)
+			require.NoError(store.Close())
+		}
+	})
+
+	t.Run("fail if max size is invalid", func(t *testing.T) {
+		require := require.New(t)
+
+		for _, v := range []string{"", "foo", "10X", "-1K"} {
+			_, err := main.NewStore(&main.StoreConfig{
+				Kind: "files",
+				Path: t.TempDir(),
+				Opts: map[string]string{"max_size": v},
+			})
+			require.ErrorContains(err, "max_size", v)
+		}
+	})
+
+	t.Run("memory store with max size", func(t *testing.T) {
+		require := require.New(t)
+
+		store, err := main.NewStore(&main.StoreConfig{
+			Kind: "memory",
+			Opts: map[string]string{"max_size": "100"},
+		})
+		require.NoError(err)
+		defer store.Close()
+
+		err = store.Put(context.Background(), DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.ErrorContains(err, "larger than")
+
+		_, err = main.NewStore(&main.StoreConfig{
+			Kind: "memory",
+			Opts: map[string]string{"max_size": "foo"},
+		})
+		require.ErrorContains(err, "max_size")
+	})
+
+	t.Run("bolt store", func(t *testing.T) {
+		require := require.New(t)
+
+		p := filepath.Join(t.TempDir(), "foo", "cache.db")
+		store, err := main.NewStore(&main.StoreConfig{Kind: "bolt", Path: p})
+		require.NoError(err)
+		require.NoError(store.Close())
+		require.FileExists(p)
+	})
+
+	t.Run("s3 store with invalid part size", func(t *testing.T) {
+		require := require.New(t)
+
+		_, err := main.NewStore(&main.StoreConfig{
+			Kind: "s3",
+			Opts: map[string]string{"bucket": "vcpkg", "part_size": "foo"},
+		})
+		require.ErrorContains(err, "part_size")
+	})
+
+	t.Run("gcs store", func(t *testing.T) {
+		require := require.New(t)
+
+		server := NewTestGcsServer(t)
+		t.Setenv("STORAGE_EMULATOR_HOST", server.URL())
+
+		store, err := main.NewStore(&main.StoreConfig{
+			Kind: "gcs",
+			Opts: map[string]string{"bucket": "vcpkg", "prefix": "cache", "chunk_size": "1MiB"},
+		})
+		require.NoError(err)
+		defer store.Close()
+
+		err = store.Put(context.Background(), DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		_, err = server.GetObject("vcpkg", "cache"+DescriptionFoo.String())
+		require.NoError(err)
+
+		_, err = main.NewStore(&main.StoreConfig{
+			Kind: "gcs",
+			Opts: map[string]string{"bucket": "vcpkg", "chunk_size": "foo"},
+		})
+		require.ErrorContains(err, "chunk_size")
+	})
+
+	t.Run("azblob store", func(t *testing.T) {
+		require := require.New(t)
+
+		t.Setenv("AZURE_STORAGE_KEY", "")
+		t.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+
+		test_cases := []struct {
+			opts map[string]string
+			msg  string
+		}{
+			{opts: map[string]string{"container": "vcpkg", "account_key": "foo"}, msg: "account"},
+			{opts: map[string]string{"account": "foo", "account_key": "foo"}, msg: "container"},
+			{opts: map[string]string{"account": "foo", "container": "vcpkg"}, msg: "credentials"},
+			{opts: map[string]string{"account": "foo", "container": "vcpkg", "account_key": "foo", "sas": "bar"}, msg: "only one"},
+			{opts: map[string]string{"account": "foo", "container": "vcpkg", "account_key": "foo", "block_size": "foo"}, msg: "block_size"},
+		}
+		for _, tc := range test_cases {
+			_, err := main.NewStore(&main.StoreConfig{Kind: "azblob", Opts: tc.opts})
+			require.ErrorContains(err, tc.msg, tc.opts)
+		}
+
+		_, name := NewTestAzblobContainer(t)
+		store, err := main.NewStore(&main.StoreConfig{
+			Kind: "azblob",
+			Opts: map[string]string{
+				"account":     azuriteAccount,
+				"account_key": azuriteAccountKey,
+				"container":   name,
+				"endpoint":    azuriteEndpoint(t),
+			},
+		})
+		require.NoError(err)
+		defer store.Close()
+
+		err = store.Put(context.Background(), DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+	})
+
+	t.Run("http store", func(t *testing.T) {
+		require := require.New(t)
+
+		server := NewTestDavServer(t)
+		store, err := main.NewStore(&main.StoreConfig{
+			Kind: "http",
+			Path: server.URL + "/dav/{name}/{version}/{sha}",
+			Opts: map[string]string{"dav": ""},
+		})
+		require.NoError(err)
+		defer store.Close()
+
+		err = store.Put(context.Background(), DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		_, err = main.NewStore(&main.StoreConfig{
+			Kind: "http",
+			Path: server.URL,
+			Opts: map[string]string{"username": "foo", "token": "bar"},
+		})
+		require.ErrorContains(err, "only one")
+	})
+
+	t.Run("sftp store", func(t *testing.T) {
+		require := require.New(t)
+
+		server := newTestSshServer(t)
+		store, err := main.NewStore(&main.StoreConfig{
+			Kind: "sftp",
+			Path: "ci@" + server.Addr + "/" + server.Root + "/cache",
+			Opts: map[string]string{
+				"key_file":    server.KeyFile,
+				"known_hosts": server.KnownHostsFile,
+			},
+		})
+		require.NoError(err)
+		defer store.Close()
+
+		err = store.Put(context.Background(), DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+		require.FileExists(filepath.Join(server.Root, "cache", "foo", "bar", "baz"))
+
+		_, err = main.NewStore(&main.StoreConfig{
+			Kind: "sftp",
+			Path: server.Addr,
+			Opts: map[string]string{"key_file": server.KeyFile},
+		})
+		require.ErrorContains(err, "user")
+
+		t.Setenv("SSH_AUTH_SOCK", "")
+		_, err = main.NewStore(&main.StoreConfig{
+			Kind: "sftp",
+			Path: "ci@" + server.Addr,
+		})
+		require.ErrorContains(err, "key_file")
+
+		// Host key is not known.
+		known_hosts := filepath.Join(t.TempDir(), "known_hosts")
+		err = os.WriteFile(known_hosts, nil, 0644)
+		require.NoError(err)
+
+		_, err = main.NewStore(&main.StoreConfig{
+			Kind: "sftp",
+			Path: "ci@" + server.Addr,
+			Opts: map[string]string{
+				"key_file":    server.KeyFile,
+				"known_hosts": known_hosts,
+			},
+		})
+		require.ErrorContains(err, "key is unknown")
+	})
+
+	t.Run("oci store", func(t *testing.T) {
+		require := require.New(t)
+
+		store, err := main.NewStore(&main.StoreConfig{
+			Kind: "oci",
+			Path: NewTestRegistry(t) + "/vcpkg",
+			Opts: map[string]string{"insecure": "", "username": "ci", "password": "s3cret"},
+		})
+		require.NoError(err)
+		defer store.Close()
+
+		err = store.Put(context.Background(), DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		_, err = main.NewStore(&main.StoreConfig{Kind: "oci"})
+		require.ErrorContains(err, "repository")
+	})
+
+	t.Run("oci store with password from file or environment variable", func(t *testing.T) {
+		require := require.New(t)
+
+		host := NewTestRegistry(t)
+
+		password_file := filepath.Join(t.TempDir(), "password")
+		err := os.WriteFile(password_file, []byte("s3cret\n"), 0o600)
+		require.NoError(err)
+
+		store, err := main.NewStore(&main.StoreConfig{
+			Kind: "oci",
+			Path: host + "/vcpkg",
+			Opts: map[string]string{"insecure": "", "username": "ci", "password_file": password_file},
+		})
+		require.NoError(err)
+		store.Close()
+
+		t.Setenv("VCPKG_CACHE_TEST_PASSWORD", "s3cret")
+		store, err = main.NewStore(&main.StoreConfig{
+			Kind: "oci",
+			Path: host + "/vcpkg",
+			Opts: map[string]string{"insecure": "", "username": "ci", "password_env": "VCPKG_CACHE_TEST_PASSWORD"},
+		})
+		require.NoError(err)
+		store.Close()
+
+		_, err = main.NewStore(&main.StoreConfig{
+			Kind: "oci",
+			Path: host + "/vcpkg",
+			Opts: map[string]string{"username": "ci", "password_env": "VCPKG_CACHE_TEST_NOT_SET"},
+		})
+		require.ErrorContains(err, "VCPKG_CACHE_TEST_NOT_SET")
+
+		_, err = main.NewStore(&main.StoreConfig{
+			Kind: "oci",
+			Path: host + "/vcpkg",
+			Opts: map[string]string{"username": "ci", "password": "s3cret", "password_file": password_file},
+		})
+		require.ErrorContains(err, "only one of")
+	})
+
+	t.Run("archives store lists entries by hash", func(t *testing.T) {
+		require := require.New(t)
+
+		root := t.TempDir()
+		store, err := main.NewStore(&main.StoreConfig{Kind: "archives", Path: root})
+		require.NoError(err)
+		defer store.Close()
+
+		ctx := context.Background()
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		// Not an archive.
+		err = os.WriteFile(filepath.Join(root, "foo.txt"), []byte("foo"), 0644)
+		require.NoError(err)
+
+		lister, ok := store.(main.Lister)
+		require.True(ok)
+
+		entries := []main.Entry{}
+		err = lister.List(ctx, func(entry main.Entry) error {
+			entries = append(entries, entry)
+			return nil
+		})
+		require.NoError(err)
+		require.Len(entries, 1)
+		require.Equal(main.Description{Hash: DescriptionFoo.Hash}, entries[0].Description)
+	})
+
+	t.Run("any store can be compressed", func(t *testing.T) {
+		require := require.New(t)
+
+		store, err := main.NewStore(&main.StoreConfig{
+			Kind: "files",
+			Path: t.TempDir(),
+			Opts: map[string]string{"compress": "zstd"},
+		})
+		require.NoError(err)
+		defer store.Close()
+
+		_, ok := store.(main.Lister)
+		require.True(ok)
+
+		_, err = main.NewStore(&main.StoreConfig{
+			Kind: "files",
+			Path: t.TempDir(),
+			Opts: map[string]string{"compress": "gzip"},
+		})
+		require.ErrorContains(err, "compress")
+	})
+
+	t.Run("any store can be encrypted", func(t *testing.T) {
+		require := require.New(t)
+
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		require.NoError(err)
+
+		t.Setenv("TEST_VCPKG_CACHE_KEYS", "1:"+base64.StdEncoding.EncodeToString(key))
+
+		store, err := main.NewStore(&main.StoreConfig{
+			Kind: "files",
+			Path: t.TempDir(),
+			Opts: map[string]string{"encrypt_key_env": "TEST_VCPKG_CACHE_KEYS", "compress": "zstd"},
+		})
+		require.NoError(err)
+		defer store.Close()
+
+		ctx := context.Background()
+		data := randomData(t)
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		received, err := readAll(ctx, store, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(data, received)
+
+		_, err = main.NewStore(&main.StoreConfig{
+			Kind: "files",
+			Path: t.TempDir(),
+			Opts: map[string]string{"encrypt_key_env": "TEST_VCPKG_CACHE_NO_KEYS"},
+		})
+		require.ErrorContains(err, "encrypt_key_env")
+	})
+
+	t.Run("compressed store spools uploads to the spool directory", func(t *testing.T) {
+		require := require.New(t)
+
+		spool := filepath.Join(t.TempDir(), "spool")
+		store, err := main.NewStore(&main.StoreConfig{
+			Kind: "files",
+			Path: t.TempDir(),
+			Opts: map[string]string{"compress": "zstd", "spool_dir": spool},
+		})
+		require.NoError(err)
+		defer store.Close()
+
+		err = store.Put(context.Background(), DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+		require.DirExists(spool)
+	})
+
+	t.Run("tiered store", func(t *testing.T) {
+		require := require.New(t)
+
+		hot := t.TempDir()
+		cold := t.TempDir()
+
+		confs := []*main.StoreConfig{
+			{Kind: "tiered", Path: "files:" + hot + "|files:" + cold, Opts: map[string]string{"write": "1"}},
+			{Kind: "tiered", Opts: map[string]string{"write": "1"}, Tiers: []*main.StoreConfig{
+				{Kind: "files", Path: hot},
+				{Kind: "files", Path: cold, Opts: map[string]string{"compress": "zstd"}},
+			}},
+		}
+		for i, conf := range confs {
+			store, err := main.NewStore(conf)
+			require.NoError(err)
+
+			desc := DescriptionFoo
+			desc.Hash += string(rune('a' + i))
+
+			ctx := context.Background()
+			err = store.Put(ctx, desc, bytes.NewReader(randomData(t)))
+			require.NoError(err)
+			require.NoError(store.Close())
+
+			require.NoDirExists(filepath.Join(hot, desc.Name))
+			require.DirExists(filepath.Join(cold, desc.Name))
+		}
+
+		_, err := main.NewStore(&main.StoreConfig{Kind: "tiered", Path: "files:" + hot, Opts: map[string]string{"write": "foo"}})
+		require.ErrorContains(err, "write")
+
+		_, err = main.NewStore(&main.StoreConfig{Kind: "tiered", Path: "files:" + hot + "|foo:"})
+		require.ErrorContains(err, "tier 1")
+	})
+
+	t.Run("nested stores with options", func(t *testing.T) {
+		require := require.New(t)
+
+		cold := t.TempDir()
+		specs := []string{
+			"proxy:http://127.0.0.1:1,local=(memory:,max_size=100)",
+			"tiered:(memory:,max_size=100)|files:" + cold + ",write=0",
+		}
+		for _, spec := range specs {
+			conf, err := main.ParseStoreConfig(spec)
+			require.NoError(err)
+
+			store, err := main.NewStore(conf)
+			require.NoError(err)
+
+			// Options are applied to the nested store rather than the outer one.
+			_, ok := conf.Opts["max_size"]
+			require.False(ok)
+
+			err = store.Put(context.Background(), DescriptionFoo, bytes.NewReader(randomData(t)))
+			require.ErrorContains(err, "larger than", spec)
+			require.NoError(store.Close())
+		}
+	})
+
+	t.Run("fail if kind is not supported", func(t *testing.T) {
+		require := require.New(t)
+
+		_, err := main.NewStore(&main.StoreConfig{Kind: "foo"})
+		require.ErrorContains(err, "not supported")
+	})
 }
 
 func writeConfig(t *testing.T, conf *main.AppConfig) string {
@@ -197,6 +671,10 @@ func TestParseArgs(t *testing.T) {
 			Host: "bar",
 			Port: 1234,
 
+			TlsCert:     "server.crt",
+			TlsKey:      "server.key",
+			TlsClientCa: "client-ca.crt",
+
 			Store: &main.StoreConfig{
 				Kind: "files",
 				Path: "store-data-here",
@@ -205,18 +683,30 @@ func TestParseArgs(t *testing.T) {
 
 			NoColor: true,
 			LogJson: true,
+			Metrics: true,
 
-			ReadOnly:  true,
-			WriteOnly: false,
+			ReadOnly:    true,
+			WriteOnly:   false,
+			AllowDelete: true,
+
+			ValidateArchives: true,
+			SpoolDir:         "/var/spool/vcpkg-cache",
 		}
 
 		conf, err := main.ParseArgs([]string{
 			"",
 			"-host", "bar",
 			"-port", "1234",
+			"-tls-cert", "server.crt",
+			"-tls-key", "server.key",
+			"-tls-client-ca", "client-ca.crt",
 			"-no-color",
 			"-log-json",
+			"-metrics",
 			"-read-only",
+			"-allow-delete",
+			"-validate-archives",
+			"-spool-dir", "/var/spool/vcpkg-cache",
 			"files:store-data-here",
 		})
 		require.NoError(err)
@@ -261,6 +751,10 @@ func TestParseArgs(t *testing.T) {
 			Host: "bar",
 			Port: 1234,
 
+			TlsCert:     "bar.crt",
+			TlsKey:      "bar.key",
+			TlsClientCa: "bar-ca.crt",
+
 			Store: nil,
 
 			NoColor: true,
@@ -274,6 +768,10 @@ func TestParseArgs(t *testing.T) {
 			Host: "foo",
 			Port: 42,
 
+			TlsCert:     "foo.crt",
+			TlsKey:      "foo.key",
+			TlsClientCa: "foo-ca.crt",
+
 			Store: nil,
 
 			NoColor: false,
@@ -288,6 +786,9 @@ func TestParseArgs(t *testing.T) {
 			"-conf", conf_path,
 			"-host", "bar",
 			"-port", "1234",
+			"-tls-cert", "bar.crt",
+			"-tls-key", "bar.key",
+			"-tls-client-ca", "bar-ca.crt",
 			"-no-color",
 			"-log-json",
 			"-read-only",
@@ -298,6 +799,55 @@ func TestParseArgs(t *testing.T) {
 		require.Equal(expected, conf)
 	})
 
+	t.Run("read retention config from file", func(t *testing.T) {
+		require := require.New(t)
+
+		conf_path := filepath.Join(t.TempDir(), "conf.json")
+		err := os.WriteFile(conf_path, []byte(`{
+			"retention": {
+				"max_age": "14d",
+				"overrides": {"boost": "90d", "qt": "0"},
+				"interval": "30m"
+			}
+		}`), 0644)
+		require.NoError(err)
+
+		conf, err := main.ParseArgs([]string{"", "-conf", conf_path})
+		require.NoError(err)
+		require.Equal(&main.RetentionConfig{
+			MaxAge: main.Duration(14 * 24 * time.Hour),
+			Overrides: map[string]main.Duration{
+				"boost": main.Duration(90 * 24 * time.Hour),
+				"qt":    0,
+			},
+			Interval: main.Duration(30 * time.Minute),
+		}, conf.Retention)
+	})
+
+	t.Run("read metrics store interval from file", func(t *testing.T) {
+		require := require.New(t)
+
+		conf_path := filepath.Join(t.TempDir(), "conf.json")
+		err := os.WriteFile(conf_path, []byte(`{"metrics": true, "metrics_store_interval": "15m"}`), 0644)
+		require.NoError(err)
+
+		conf, err := main.ParseArgs([]string{"", "-conf", conf_path})
+		require.NoError(err)
+		require.True(conf.Metrics)
+		require.Equal(main.Duration(15*time.Minute), conf.MetricsStoreInterval)
+	})
+
+	t.Run("fail if duration is invalid", func(t *testing.T) {
+		require := require.New(t)
+
+		conf_path := filepath.Join(t.TempDir(), "conf.json")
+		err := os.WriteFile(conf_path, []byte(`{"retention": {"max_age": "14x"}}`), 0644)
+		require.NoError(err)
+
+		_, err = main.ParseArgs([]string{"", "-conf", conf_path})
+		require.ErrorContains(err, "unmarshal config")
+	})
+
 	t.Run("fail if store config is invalid", func(t *testing.T) {
 		require := require.New(t)
 
@@ -347,4 +897,25 @@ func TestParseArgsStrict(t *testing.T) {
 		_, err := main.ParseArgsStrict([]string{"", "-read-only", "-write-only"})
 		require.ErrorContains(err, "cannot be set together")
 	})
+
+	t.Run("read-only and allow-delete cannot be set together", func(t *testing.T) {
+		require := require.New(t)
+
+		_, err := main.ParseArgsStrict([]string{"", "-read-only", "-allow-delete"})
+		require.ErrorContains(err, "cannot be set together")
+	})
+
+	t.Run("retention overrides cannot be applied to archives store", func(t *testing.T) {
+		require := require.New(t)
+
+		conf_path := filepath.Join(t.TempDir(), "conf.json")
+		err := os.WriteFile(conf_path, []byte(`{
+			"store": {"kind": "archives"},
+			"retention": {"max_age": "14d", "overrides": {"boost": "90d"}}
+		}`), 0644)
+		require.NoError(err)
+
+		_, err = main.ParseArgsStrict([]string{"", "-conf", conf_path})
+		require.ErrorIs(err, main.ErrUnnamed)
+	})
 }
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Encrypted artifacts are sealed in chunks by AES-GCM in the STREAM construction
+// so they can be streamed without loading the whole artifact on memory.
+//
+//	header: magic(4) | key ID(4) | salt(32) | nonce prefix(7) | reserved(1)
+//	chunk:  AES-GCM(plaintext of cryptChunkSize bytes or less) with tag(16)
+//
+// Chunks are sealed by the key derived from the key of the ID and the salt by HKDF-SHA256
+// so each artifact has its own key and nonces never repeat under the same key even if the prefixes collide.
+// Nonce of each chunk is the prefix, index of the chunk(4) and 1 if the chunk is the last one otherwise 0.
+// The header and the ABI hash are authenticated by each chunk.
+const (
+	cryptHeaderLen = 48
+	cryptSaltSize  = 32
+	cryptChunkSize = 64 * 1024
+	cryptTagSize   = 16
+)
+
+var (
+	cryptMagic    = []byte("VCE\x01")
+	cryptHkdfInfo = []byte("vcpkg-cache-http artifact")
+)
+
+func cryptSalt(header []byte) []byte {
+	return header[8 : 8+cryptSaltSize]
+}
+
+func cryptNoncePrefix(header []byte) []byte {
+	return header[8+cryptSaltSize : 8+cryptSaltSize+7]
+}
+
+// CryptKeys is a set of keys identified by their IDs.
+// The key with the largest ID is used to encrypt and all keys are used to decrypt
+// so keys can be rotated by adding a new key with a larger ID.
+type CryptKeys struct {
+	active uint32
+	keys   map[uint32][]byte
+}
+
+// ParseCryptKeys parses keys in the form of `id:key` separated by commas or whitespaces
+// where `id` is an unsigned integer and `key` is 16, 24 or 32 bytes encoded in base64.
+// Lines starting with `#` are ignored.
+func ParseCryptKeys(s string) (*CryptKeys, error) {
+	keys := &CryptKeys{keys: map[uint32][]byte{}}
+
+	lines := strings.Split(s, "\n")
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		entries := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\r'
+		})
+		for _, entry := range entries {
+			id_str, key_str, ok := strings.Cut(entry, ":")
+			if !ok {
+				return nil, errors.New("key must be in the form of id:key")
+			}
+
+			id, err := strconv.ParseUint(id_str, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid key ID %q: %w", id_str, err)
+			}
+			if _, ok := keys.keys[uint32(id)]; ok {
+				return nil, fmt.Errorf("duplicated key ID %d", id)
+			}
+
+			key, err := base64.StdEncoding.DecodeString(key_str)
+			if err != nil {
+				return nil, fmt.Errorf("decode key %d: %w", id, err)
+			}
+
+			if _, err := aes.NewCipher(key); err != nil {
+				return nil, fmt.Errorf("key %d: %w", id, err)
+			}
+
+			keys.keys[uint32(id)] = key
+			if uint32(id) > keys.active || len(keys.keys) == 1 {
+				keys.active = uint32(id)
+			}
+		}
+	}
+	if len(keys.keys) == 0 {
+		return nil, errors.New("no key given")
+	}
+
+	return keys, nil
+}
+
+// aead returns the cipher of the key derived from the key of `id` and the salt of an artifact.
+func (k *CryptKeys) aead(id uint32, salt []byte) (cipher.AEAD, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("key %d not found", id)
+	}
+
+	// Derived key has the same size as the given one so is the strength of AES.
+	derived := make([]byte, len(key))
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, salt, cryptHkdfInfo), derived); err != nil {
+		return nil, fmt.Errorf("derive key: %w", err)
+	}
+
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// LoadCryptKeys loads keys from the file at `p`.
+func LoadCryptKeys(p string) (*CryptKeys, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("read keys: %w", err)
+	}
+
+	return ParseCryptKeys(string(data))
+}
+
+// cryptStore encrypts artifacts before storing them to the underlying store.
+// All artifacts in the underlying store must be encrypted by the store.
+type cryptStore struct {
+	store Store
+	keys  *CryptKeys
+}
+
+func NewCryptStore(store Store, keys *CryptKeys) *cryptStore {
+	return &cryptStore{store: store, keys: keys}
+}
+
+func cryptNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[7:], index)
+	if last {
+		nonce[11] = 1
+	}
+
+	return nonce
+}
+
+func cryptAdditionalData(header []byte, desc Description) []byte {
+	return append(append([]byte{}, header...), desc.Hash...)
+}
+
+// cryptPlainSize returns the size of the plaintext from the size of the encrypted artifact.
+func cryptPlainSize(size int64) (int64, error) {
+	body := size - cryptHeaderLen
+	if body < cryptTagSize {
+		return 0, fmt.Errorf("%w: encrypted artifact is too small", ErrCorrupted)
+	}
+
+	chunks := (body + cryptChunkSize + cryptTagSize - 1) / (cryptChunkSize + cryptTagSize)
+	return body - chunks*cryptTagSize, nil
+}
+
+// sealReader reads the plaintext from `r` and produces the encrypted artifact.
+type sealReader struct {
+	r     *bufio.Reader
+	aead  cipher.AEAD
+	ad    []byte
+	nonce []byte
+
+	index uint32
+	buf   []byte
+	out   []byte
+	done  bool
+}
+
+func (s *sealReader) Read(b []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+
+		n, err := io.ReadFull(s.r, s.buf)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+
+		last := err != nil
+		if !last {
+			if _, err := s.r.Peek(1); errors.Is(err, io.EOF) {
+				last = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+
+		s.out = s.aead.Seal(s.out[:0], cryptNonce(s.nonce, s.index, last), s.buf[:n], s.ad)
+		s.index++
+		s.done = last
+	}
+
+	n := copy(b, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+// openReader reads the encrypted chunks from `r` and produces the plaintext.
+type openReader struct {
+	r     *bufio.Reader
+	c     io.Closer
+	aead  cipher.AEAD
+	ad    []byte
+	nonce []byte
+
+	index uint32
+	buf   []byte
+	out   []byte
+	done  bool
+}
+
+func (o *openReader) Read(b []byte) (int, error) {
+	for len(o.out) == 0 {
+		if o.done {
+			return 0, io.EOF
+		}
+
+		n, err := io.ReadFull(o.r, o.buf)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+
+		last := err != nil
+		if !last {
+			if _, err := o.r.Peek(1); errors.Is(err, io.EOF) {
+				last = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+
+		out, err := o.aead.Open(o.out[:0], cryptNonce(o.nonce, o.index, last), o.buf[:n], o.ad)
+		if err != nil {
+			return 0, fmt.Errorf("%w: decrypt chunk %d: %s", ErrCorrupted, o.index, err.Error())
+		}
+
+		o.out = out
+		o.index++
+		o.done = last
+	}
+
+	n := copy(b, o.out)
+	o.out = o.out[n:]
+	return n, nil
+}
+
+func (o *openReader) Close() error {
+	return o.c.Close()
+}
+
+func (s *cryptStore) Get(ctx context.Context, desc Description) (io.ReadCloser, Info, error) {
+	r, info, err := s.store.Get(ctx, desc)
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	header := make([]byte, cryptHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		r.Close()
+		return nil, Info{}, fmt.Errorf("%w: read header: %s", ErrCorrupted, err.Error())
+	}
+	if string(header[:4]) != string(cryptMagic) {
+		r.Close()
+		return nil, Info{}, fmt.Errorf("%w: artifact is not encrypted", ErrCorrupted)
+	}
+
+	aead, err := s.keys.aead(binary.BigEndian.Uint32(header[4:]), cryptSalt(header))
+	if err != nil {
+		r.Close()
+		return nil, Info{}, err
+	}
+
+	// Digest of the underlying store is of the encrypted one.
+	info.Digest = ""
+	info.Seekable = false
+	if info.Size >= 0 {
+		if info.Size, err = cryptPlainSize(info.Size); err != nil {
+			r.Close()
+			return nil, Info{}, err
+		}
+	}
+
+	return &openReader{
+		r:     bufio.NewReaderSize(r, cryptChunkSize+cryptTagSize),
+		c:     r,
+		aead:  aead,
+		ad:    cryptAdditionalData(header, desc),
+		nonce: cryptNoncePrefix(header),
+
+		buf: make([]byte, cryptChunkSize+cryptTagSize),
+	}, info, nil
+}
+
+func (s *cryptStore) Head(ctx context.Context, desc Description) (Info, error) {
+	info, err := s.store.Head(ctx, desc)
+	if err != nil {
+		return Info{}, err
+	}
+
+	info.Digest = ""
+	info.Seekable = false
+	if info.Size >= 0 {
+		if info.Size, err = cryptPlainSize(info.Size); err != nil {
+			return Info{}, err
+		}
+	}
+
+	return info, nil
+}
+
+func (s *cryptStore) Put(ctx context.Context, desc Description, r io.Reader) error {
+	header := make([]byte, cryptHeaderLen)
+	copy(header, cryptMagic)
+	binary.BigEndian.PutUint32(header[4:], s.keys.active)
+	if _, err := rand.Read(header[8 : 8+cryptSaltSize+7]); err != nil {
+		return fmt.Errorf("generate salt and nonce: %w", err)
+	}
+
+	aead, err := s.keys.aead(s.keys.active, cryptSalt(header))
+	if err != nil {
+		return err
+	}
+
+	return s.store.Put(ctx, desc, io.MultiReader(
+		bytes.NewReader(header),
+		&sealReader{
+			r:     bufio.NewReaderSize(r, cryptChunkSize),
+			aead:  aead,
+			ad:    cryptAdditionalData(header, desc),
+			nonce: cryptNoncePrefix(header),
+
+			buf: make([]byte, cryptChunkSize),
+		},
+	))
+}
+
+func (s *cryptStore) Delete(ctx context.Context, desc Description) error {
+	return s.store.Delete(ctx, desc)
+}
+
+// List lists the entries in the underlying store.
+// Note that sizes of the entries are the encrypted ones.
+func (s *cryptStore) List(ctx context.Context, fn func(entry Entry) error) error {
+	lister, ok := s.store.(Lister)
+	if !ok {
+		return errors.New("underlying store cannot enumerate its entries")
+	}
+
+	return lister.List(ctx, func(entry Entry) error {
+		// Digest of the underlying store is of the encrypted one.
+		entry.Digest = ""
+		return fn(entry)
+	})
+}
+
+func (s *cryptStore) Close() error {
+	return s.store.Close()
+}
@@ -0,0 +1,244 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+
+	main "github.com/lesomnus/vcpkg-cache-http"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+func newTestCryptKey(t *testing.T) string {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+type CryptStoreSetup struct{}
+
+func (s *CryptStoreSetup) New(t *testing.T) (main.Store, error) {
+	store, err := NewTestFsStore(t)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := main.ParseCryptKeys("1:" + newTestCryptKey(t))
+	if err != nil {
+		return nil, err
+	}
+
+	return main.NewCryptStore(store, keys), nil
+}
+
+func TestCryptStoreSuite(t *testing.T) {
+	suite.Run(t, &StoreTestSuite{Store: &CryptStoreSetup{}})
+}
+
+func TestParseCryptKeys(t *testing.T) {
+	key := newTestCryptKey(t)
+
+	t.Run("keys", func(t *testing.T) {
+		for _, v := range []string{
+			"1:" + key,
+			"1:" + key + ",2:" + key,
+			"# old key\n1:" + key + "\n# new key\n2:" + key + "\n",
+		} {
+			_, err := main.ParseCryptKeys(v)
+			require.NoError(t, err, v)
+		}
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		test_cases := []struct {
+			keys string
+			msg  string
+		}{
+			{keys: "", msg: "no key"},
+			{keys: key, msg: "id:key"},
+			{keys: "foo:" + key, msg: "invalid key ID"},
+			{keys: "1:" + key + ",1:" + key, msg: "duplicated"},
+			{keys: "1:foo", msg: "decode"},
+			{keys: "1:" + base64.StdEncoding.EncodeToString([]byte("foo")), msg: "key size"},
+		}
+		for _, tc := range test_cases {
+			_, err := main.ParseCryptKeys(tc.keys)
+			require.ErrorContains(t, err, tc.msg, tc.keys)
+		}
+	})
+}
+
+func TestCryptStore(t *testing.T) {
+	key1 := newTestCryptKey(t)
+	key2 := newTestCryptKey(t)
+
+	newStore := func(t *testing.T, store main.Store, keys string) main.Store {
+		k, err := main.ParseCryptKeys(keys)
+		require.NoError(t, err)
+
+		return main.NewCryptStore(store, k)
+	}
+
+	t.Run("artifact is encrypted at rest", func(t *testing.T) {
+		require := require.New(t)
+
+		fs_store, err := main.NewFsStore(t.TempDir())
+		require.NoError(err)
+
+		store := newStore(t, fs_store, "1:"+key1)
+
+		ctx := context.Background()
+		for i, size := range []int{0, 1, 64 * 1024, 64*1024 + 1, 200 * 1024} {
+			desc := DescriptionFoo
+			desc.Hash += string(rune('a' + i))
+
+			data := bytes.Repeat([]byte("Royale with Cheese"), size/18+1)[:size]
+			err := store.Put(ctx, desc, bytes.NewReader(data))
+			require.NoError(err)
+
+			stored, err := os.ReadFile(fs_store.Resolve(desc))
+			require.NoError(err)
+			if size > 0 {
+				require.NotContains(string(stored), "Royale with Cheese")
+			}
+
+			info, err := store.Head(ctx, desc)
+			require.NoError(err)
+			require.Equal(int64(size), info.Size, size)
+
+			received, err := readAll(ctx, store, desc)
+			require.NoError(err)
+			require.Equal(data, received, size)
+		}
+	})
+
+	t.Run("each artifact has its own salt", func(t *testing.T) {
+		require := require.New(t)
+
+		fs_store, err := main.NewFsStore(t.TempDir())
+		require.NoError(err)
+
+		store := newStore(t, fs_store, "1:"+key1)
+
+		ctx := context.Background()
+		data := randomData(t)
+		foo := DescriptionFoo
+		bar := main.Description{Name: "foo", Version: "bar", Hash: "qux"}
+
+		salts := map[string]struct{}{}
+		for _, desc := range []main.Description{foo, bar} {
+			err := store.Put(ctx, desc, bytes.NewReader(data))
+			require.NoError(err)
+
+			stored, err := os.ReadFile(fs_store.Resolve(desc))
+			require.NoError(err)
+			salts[string(stored[8:40])] = struct{}{}
+		}
+		require.Len(salts, 2)
+	})
+
+	t.Run("keys are rotated", func(t *testing.T) {
+		require := require.New(t)
+
+		fs_store, err := main.NewFsStore(t.TempDir())
+		require.NoError(err)
+
+		ctx := context.Background()
+		data := randomData(t)
+		foo := DescriptionFoo
+		bar := main.Description{Name: "foo", Version: "bar", Hash: "qux"}
+
+		err = newStore(t, fs_store, "1:"+key1).Put(ctx, foo, bytes.NewReader(data))
+		require.NoError(err)
+
+		store := newStore(t, fs_store, "2:"+key2+",1:"+key1)
+		err = store.Put(ctx, bar, bytes.NewReader(data))
+		require.NoError(err)
+
+		for id, desc := range map[uint32]main.Description{1: foo, 2: bar} {
+			stored, err := os.ReadFile(fs_store.Resolve(desc))
+			require.NoError(err)
+			require.Equal(id, binary.BigEndian.Uint32(stored[4:8]))
+
+			received, err := readAll(ctx, store, desc)
+			require.NoError(err)
+			require.Equal(data, received)
+		}
+
+		// Artifacts encrypted by the removed key cannot be read.
+		store = newStore(t, fs_store, "2:"+key2)
+		_, _, err = store.Get(ctx, foo)
+		require.ErrorContains(err, "key 1 not found")
+	})
+
+	t.Run("tampered artifact cannot be read", func(t *testing.T) {
+		require := require.New(t)
+
+		fs_store, err := main.NewFsStore(t.TempDir())
+		require.NoError(err)
+
+		store := newStore(t, fs_store, "1:"+key1)
+
+		ctx := context.Background()
+		data := make([]byte, 200*1024)
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		stored, err := os.ReadFile(fs_store.Resolve(DescriptionFoo))
+		require.NoError(err)
+
+		corrupt := func(t *testing.T, stored []byte) main.Store {
+			s, err := NewTestFsStore(t)
+			require.NoError(err)
+			err = s.Put(ctx, DescriptionFoo, bytes.NewReader(stored))
+			require.NoError(err)
+
+			return newStore(t, s, "1:"+key1)
+		}
+
+		// Truncated at the chunk boundary.
+		r, _, err := corrupt(t, stored[:48+2*(64*1024+16)]).Get(ctx, DescriptionFoo)
+		require.NoError(err)
+		_, err = io.ReadAll(r)
+		require.ErrorIs(err, main.ErrCorrupted)
+		r.Close()
+
+		// Bit flipped.
+		flipped := append([]byte{}, stored...)
+		flipped[len(flipped)/2] ^= 1
+		r, _, err = corrupt(t, flipped).Get(ctx, DescriptionFoo)
+		require.NoError(err)
+		_, err = io.ReadAll(r)
+		require.ErrorIs(err, main.ErrCorrupted)
+		r.Close()
+
+		// Salt flipped.
+		flipped = append([]byte{}, stored...)
+		flipped[8] ^= 1
+		r, _, err = corrupt(t, flipped).Get(ctx, DescriptionFoo)
+		require.NoError(err)
+		_, err = io.ReadAll(r)
+		require.ErrorIs(err, main.ErrCorrupted)
+		r.Close()
+
+		// Moved to other ABI hash.
+		s, err := NewTestFsStore(t)
+		require.NoError(err)
+		desc := main.Description{Name: "foo", Version: "bar", Hash: "qux"}
+		err = s.Put(ctx, desc, bytes.NewReader(stored))
+		require.NoError(err)
+		r, _, err = newStore(t, s, "1:"+key1).Get(ctx, desc)
+		require.NoError(err)
+		_, err = io.ReadAll(r)
+		require.ErrorIs(err, main.ErrCorrupted)
+		r.Close()
+	})
+}
@@ -1,8 +1,27 @@
 package main
 
-import "os"
+import (
+	"errors"
+	"os"
+)
 
 var (
 	ErrExist    = os.ErrExist
 	ErrNotExist = os.ErrNotExist
+
+	// ErrCorrupted is returned if the stored artifact does not match the digest computed on upload.
+	ErrCorrupted = errors.New("artifact is corrupted")
+
+	// ErrTooLarge is returned if the artifact exceeds the capacity of the store.
+	ErrTooLarge = errors.New("artifact is too large")
+
+	// ErrInvalidDescription is returned if a field of the description cannot be a segment of a path.
+	ErrInvalidDescription = errors.New("invalid description")
+
+	// ErrInvalidArchive is returned if the uploaded artifact is not a package archive built by vcpkg.
+	ErrInvalidArchive = errors.New("invalid archive")
+
+	// ErrUnnamed is returned if entries are selected by their names
+	// but the store does not record them, such as `archives` store.
+	ErrUnnamed = errors.New("store does not record names of its entries")
 )
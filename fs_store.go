@@ -2,21 +2,77 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 func fsStoreDefaultResolve(desc Description) string {
 	return filepath.Join(desc.Name, desc.Version, desc.Hash)
 }
 
+func fsStoreDefaultUnresolve(key string) (Description, bool) {
+	entries := strings.Split(filepath.ToSlash(key), "/")
+	if len(entries) != 3 {
+		return Description{}, false
+	}
+
+	return Description{
+		Name:    entries[0],
+		Version: entries[1],
+		Hash:    entries[2],
+	}, true
+}
+
+// Directory under the root where corrupted files are moved to.
+const fsStoreQuarantineDir = ".quarantine"
+
+// fsStoreDigestPath returns the path of the file that holds SHA-256 digest of the file at `p`.
+// It is hidden so it is not listed as an entry of the store.
+func fsStoreDigestPath(p string) string {
+	return filepath.Join(filepath.Dir(p), "."+filepath.Base(p)+".sha256")
+}
+
 type fsStore struct {
 	root string
 	work string
 
-	resolve func(desc Description) string
+	resolve   func(desc Description) string
+	unresolve func(key string) (Description, bool)
+
+	// Guards directories in the store from being removed
+	// while a file is being moved into there.
+	mutex sync.Mutex
+
+	// Files verified against their digests, so a file is hashed once
+	// rather than on every read such as resumed range requests.
+	verified       map[string]fsVerified
+	verified_mutex sync.Mutex
+
+	// Total size of the files in the store is limited to `max_size` if it is not 0.
+	// Files are evicted in least recently accessed order.
+	max_size int64
+	index    *lruIndex
+	evicting chan struct{}
+	closed   chan struct{}
+	close    sync.Once
+}
+
+// fsVerified is the state of a file when it was verified.
+// The file is verified again if it is modified.
+type fsVerified struct {
+	size     int64
+	mod_time time.Time
+	digest   string
 }
 
 type fsOption func(s *fsStore)
@@ -33,8 +89,24 @@ func WithPathResolve(resolve func(desc Description) string) fsOption {
 	}
 }
 
+// WithPathUnresolve sets the reverse of the path resolve so that the store can list its entries.
+// `unresolve` returns false if the given path is not an entry of the store.
+func WithPathUnresolve(unresolve func(key string) (Description, bool)) fsOption {
+	return func(s *fsStore) {
+		s.unresolve = unresolve
+	}
+}
+
+// WithMaxSize limits total size of the files in the store.
+// Least recently accessed files are evicted if the total size exceeds the limit.
+func WithMaxSize(size int64) fsOption {
+	return func(s *fsStore) {
+		s.max_size = size
+	}
+}
+
 func NewFsStore(root string, opts ...fsOption) (*fsStore, error) {
-	s := &fsStore{root: root}
+	s := &fsStore{root: root, verified: map[string]fsVerified{}}
 	for _, opt := range opts {
 		opt(s)
 	}
@@ -44,6 +116,9 @@ func NewFsStore(root string, opts ...fsOption) (*fsStore, error) {
 	}
 	if s.resolve == nil {
 		s.resolve = fsStoreDefaultResolve
+		if s.unresolve == nil {
+			s.unresolve = fsStoreDefaultUnresolve
+		}
 	}
 
 	if err := os.MkdirAll(s.root, 0744); err != nil {
@@ -90,37 +165,307 @@ func NewFsStore(root string, opts ...fsOption) (*fsStore, error) {
 		return nil, fmt.Errorf("test fail: %w", err)
 	}
 
+	if s.max_size > 0 {
+		if err := s.startEvictor(); err != nil {
+			return nil, err
+		}
+	}
+
 	return s, nil
 }
 
+func (s *fsStore) startEvictor() error {
+	type file struct {
+		key      string
+		size     int64
+		mod_time time.Time
+	}
+
+	files := []file{}
+	err := s.walk(func(key string, info fs.FileInfo) error {
+		files = append(files, file{key: key, size: info.Size(), mod_time: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("scan store directory: %w", err)
+	}
+
+	// Access time is not tracked across restarts so modification time is used instead.
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].mod_time.Before(files[j].mod_time)
+	})
+
+	s.index = newLruIndex()
+	for _, f := range files {
+		s.index.Add(f.key, f.size)
+	}
+
+	s.evicting = make(chan struct{}, 1)
+	s.closed = make(chan struct{})
+	go func() {
+		for {
+			s.evict()
+
+			select {
+			case <-s.closed:
+				return
+			case <-s.evicting:
+			}
+		}
+	}()
+
+	return nil
+}
+
+// walk calls `fn` for each file in the store with its key relative to the root.
+// Hidden files and directories such as the work directory are skipped.
+func (s *fsStore) walk(fn func(key string, info fs.FileInfo) error) error {
+	return walkFiles(os.DirFS(s.root), func(key string, info fs.FileInfo) error {
+		return fn(filepath.FromSlash(key), info)
+	})
+}
+
+// walkFiles calls `fn` with the slash-separated path and the info of each regular file in `fsys`
+// except hidden ones, e.g. the work directory.
+// Files removed while walking are skipped.
+func walkFiles(fsys fs.FS, fn func(key string, info fs.FileInfo) error) error {
+	return fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p != "." && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && p != "." {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		return fn(p, info)
+	})
+}
+
+func (s *fsStore) evict() {
+	for s.index.Size() > s.max_size {
+		key, _, ok := s.index.Oldest()
+		if !ok {
+			return
+		}
+
+		s.remove(key)
+		s.index.Remove(key)
+	}
+}
+
+// remove removes the file at given key with its digest and its parent directories if they are empty.
+func (s *fsStore) remove(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p := filepath.Join(s.root, key)
+	if err := os.Remove(p); err != nil {
+		return err
+	}
+	os.Remove(fsStoreDigestPath(p))
+	s.forget(key)
+
+	s.removeEmptyDirs(key)
+	return nil
+}
+
+// removeEmptyDirs removes parent directories of the given key if they are empty.
+// `mutex` must be held.
+func (s *fsStore) removeEmptyDirs(key string) {
+	for d := filepath.Dir(key); d != "." && d != string(filepath.Separator); d = filepath.Dir(d) {
+		if err := os.Remove(filepath.Join(s.root, d)); err != nil {
+			break
+		}
+	}
+}
+
+// quarantine moves the file at given key with its digest out of the store
+// so it can be inspected later and the artifact can be uploaded again.
+func (s *fsStore) quarantine(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	src := filepath.Join(s.root, key)
+	dst := filepath.Join(s.root, fsStoreQuarantineDir, key)
+	if err := os.MkdirAll(filepath.Dir(dst), 0744); err != nil {
+		return fmt.Errorf("create quarantine directory: %w", err)
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return fmt.Errorf("move file to quarantine: %w", err)
+	}
+	os.Rename(fsStoreDigestPath(src), fsStoreDigestPath(dst))
+	s.forget(key)
+
+	s.removeEmptyDirs(key)
+	if s.index != nil {
+		s.index.Remove(key)
+	}
+
+	return nil
+}
+
+// verify checks if the content of `f` at given key matches the digest computed on upload and returns the digest.
+// Files without digest, e.g. ones stored by older versions or by vcpkg itself, are not verified.
+// Files already verified are not hashed again unless their size or modification time is changed.
+// `f` is rewound to the beginning.
+func (s *fsStore) verify(key string, f *os.File, info fs.FileInfo) (string, error) {
+	s.verified_mutex.Lock()
+	v, ok := s.verified[key]
+	s.verified_mutex.Unlock()
+	if ok && v.size == info.Size() && v.mod_time.Equal(info.ModTime()) {
+		return v.digest, nil
+	}
+
+	expected, err := readDigest(f.Name())
+	if err != nil {
+		return "", err
+	}
+	if expected == "" {
+		return "", nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("compute digest: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	actual := hex.EncodeToString(h.Sum(nil))
+	if actual != expected {
+		return "", fmt.Errorf("%w: expected sha256 %s but was %s", ErrCorrupted, expected, actual)
+	}
+
+	s.verified_mutex.Lock()
+	s.verified[key] = fsVerified{size: info.Size(), mod_time: info.ModTime(), digest: actual}
+	s.verified_mutex.Unlock()
+
+	return actual, nil
+}
+
+// forget drops the verification of the file at given key.
+func (s *fsStore) forget(key string) {
+	s.verified_mutex.Lock()
+	delete(s.verified, key)
+	s.verified_mutex.Unlock()
+}
+
+// readDigest reads the digest of the file at `p`.
+// It returns an empty string if the file does not have a digest.
+func readDigest(p string) (string, error) {
+	data, err := os.ReadFile(fsStoreDigestPath(p))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", fmt.Errorf("read digest: %w", err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (s *fsStore) touch(desc Description) {
+	if s.index == nil {
+		return
+	}
+
+	s.index.Touch(s.resolve(desc))
+}
+
 func (s *fsStore) Resolve(desc Description) string {
 	return filepath.Join(s.root, s.resolve(desc))
 }
 
-func (s *fsStore) Get(ctx context.Context, desc Description, w io.Writer) error {
+func (s *fsStore) Get(ctx context.Context, desc Description) (io.ReadCloser, Info, error) {
+	if err := desc.Validate(); err != nil {
+		return nil, Info{}, err
+	}
+
 	tgt := s.Resolve(desc)
 	f, err := os.OpenFile(tgt, os.O_RDONLY, 0)
 	if err != nil {
-		return fmt.Errorf("open file: %w", err)
+		return nil, Info{}, fmt.Errorf("open file: %w", err)
 	}
 
-	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, Info{}, fmt.Errorf("stat file: %w", err)
+	}
+
+	digest, err := s.verify(s.resolve(desc), f, info)
+	if err != nil {
+		f.Close()
+		if errors.Is(err, ErrCorrupted) {
+			if err_q := s.quarantine(s.resolve(desc)); err_q != nil {
+				return nil, Info{}, fmt.Errorf("%w; %s", err, err_q.Error())
+			}
+			return nil, Info{}, fmt.Errorf("%w; quarantined", err)
+		}
+		return nil, Info{}, err
+	}
 
-	_, err = io.Copy(w, f)
-	return err
+	s.touch(desc)
+	return f, Info{Size: info.Size(), ModTime: info.ModTime(), Digest: digest, Seekable: true}, nil
 }
 
-func (s *fsStore) Head(ctx context.Context, desc Description) (int, error) {
+func (s *fsStore) Head(ctx context.Context, desc Description) (Info, error) {
+	if err := desc.Validate(); err != nil {
+		return Info{}, err
+	}
+
 	tgt := s.Resolve(desc)
 	info, err := os.Stat(tgt)
 	if err != nil {
+		return Info{}, err
+	}
+
+	digest, err := readDigest(tgt)
+	if err != nil {
+		return Info{}, err
+	}
+
+	s.touch(desc)
+	return Info{Size: info.Size(), ModTime: info.ModTime(), Digest: digest, Seekable: true}, nil
+}
+
+// ReadAt reads the file without verifying it.
+func (s *fsStore) ReadAt(ctx context.Context, desc Description, p []byte, off int64) (int, error) {
+	if err := desc.Validate(); err != nil {
 		return 0, err
 	}
 
-	return int(info.Size()), nil
+	f, err := os.Open(s.Resolve(desc))
+	if err != nil {
+		return 0, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	return f.ReadAt(p, off)
 }
 
 func (s *fsStore) Put(ctx context.Context, desc Description, r io.Reader) error {
+	if err := desc.Validate(); err != nil {
+		return err
+	}
 	if err := os.MkdirAll(s.work, 0744); err != nil {
 		return fmt.Errorf("create work directory: %w", err)
 	}
@@ -135,16 +480,91 @@ func (s *fsStore) Put(ctx context.Context, desc Description, r io.Reader) error
 		return fmt.Errorf("create temp file: %w", err)
 	}
 
-	_, err = io.Copy(f, r)
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), r)
+	if err == nil {
+		// Make sure the content is on the disk before the digest is
+		// so a crash does not leave a truncated file that looks valid.
+		err = f.Sync()
+	}
 	f.Close()
 	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := s.move(f.Name(), tgt, hex.EncodeToString(h.Sum(nil))); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if s.index == nil {
+		return nil
+	}
+
+	if info, err := os.Stat(tgt); err == nil {
+		s.index.Add(s.resolve(desc), info.Size())
+	}
+
+	select {
+	case s.evicting <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (s *fsStore) List(ctx context.Context, fn func(entry Entry) error) error {
+	if s.unresolve == nil {
+		return errors.New("listing is not supported for the custom path layout")
+	}
+
+	return s.walk(func(key string, info fs.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		desc, ok := s.unresolve(key)
+		if !ok {
+			return nil
+		}
+
+		return fn(Entry{
+			Description: desc,
+			Size:        info.Size(),
+			ModTime:     info.ModTime(),
+		})
+	})
+}
+
+func (s *fsStore) Delete(ctx context.Context, desc Description) error {
+	if err := desc.Validate(); err != nil {
+		return err
+	}
+
+	key := s.resolve(desc)
+	if err := s.remove(key); err != nil {
 		return err
 	}
+	if s.index != nil {
+		s.index.Remove(key)
+	}
+
+	return nil
+}
+
+// move moves the file at `src` to `dst` with its digest.
+func (s *fsStore) move(src string, dst string, digest string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	if err := os.MkdirAll(filepath.Dir(tgt), 0744); err != nil {
+	if err := os.MkdirAll(filepath.Dir(dst), 0744); err != nil {
 		return fmt.Errorf("create target directory: %w", err)
 	}
-	if err := os.Rename(f.Name(), tgt); err != nil {
+	if err := os.WriteFile(fsStoreDigestPath(dst), []byte(digest+"\n"), 0644); err != nil {
+		return fmt.Errorf("write digest: %w", err)
+	}
+	if err := os.Rename(src, dst); err != nil {
+		os.Remove(fsStoreDigestPath(dst))
 		return fmt.Errorf("move received file to storage: %w", err)
 	}
 
@@ -152,5 +572,11 @@ func (s *fsStore) Put(ctx context.Context, desc Description, r io.Reader) error
 }
 
 func (s *fsStore) Close() error {
+	if s.closed != nil {
+		s.close.Do(func() {
+			close(s.closed)
+		})
+	}
+
 	return os.Remove(s.work)
 }
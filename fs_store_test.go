@@ -96,6 +96,259 @@ func TestFsStore(t *testing.T) {
 	})
 }
 
+func TestFsStoreList(t *testing.T) {
+	require := require.New(t)
+
+	store, err := main.NewFsStore(t.TempDir())
+	require.NoError(err)
+
+	descs := []main.Description{
+		{Name: "foo", Version: "bar", Hash: "a"},
+		{Name: "foo", Version: "baz", Hash: "b"},
+		{Name: "qux", Version: "bar", Hash: "c"},
+	}
+
+	ctx := context.Background()
+	for _, desc := range descs {
+		err := store.Put(ctx, desc, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+	}
+
+	entries := []main.Entry{}
+	err = store.List(ctx, func(entry main.Entry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	require.NoError(err)
+	require.Len(entries, len(descs))
+	for i, entry := range entries {
+		require.Equal(descs[i], entry.Description)
+		require.Equal(int64(128), entry.Size)
+		require.WithinDuration(time.Now(), entry.ModTime, time.Minute)
+	}
+}
+
+func TestFsStoreDelete(t *testing.T) {
+	require := require.New(t)
+
+	root := t.TempDir()
+	store, err := main.NewFsStore(root)
+	require.NoError(err)
+
+	ctx := context.Background()
+	err = store.Delete(ctx, DescriptionFoo)
+	require.ErrorIs(err, main.ErrNotExist)
+
+	err = store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(t)))
+	require.NoError(err)
+
+	err = store.Delete(ctx, DescriptionFoo)
+	require.NoError(err)
+
+	_, err = store.Head(ctx, DescriptionFoo)
+	require.ErrorIs(err, main.ErrNotExist)
+	require.NoDirExists(filepath.Join(root, DescriptionFoo.Name))
+
+	// Files outside of the root are not reachable.
+	base := t.TempDir()
+	err = os.WriteFile(filepath.Join(base, "victim"), nil, 0644)
+	require.NoError(err)
+
+	store, err = main.NewFsStore(filepath.Join(base, "a", "b"))
+	require.NoError(err)
+
+	err = store.Delete(ctx, main.Description{Name: "..", Version: "..", Hash: "victim"})
+	require.ErrorIs(err, main.ErrInvalidDescription)
+	require.FileExists(filepath.Join(base, "victim"))
+	require.DirExists(filepath.Join(base, "a"))
+}
+
+func TestFsStoreIntegrity(t *testing.T) {
+	t.Run("corrupted file is quarantined", func(t *testing.T) {
+		require := require.New(t)
+
+		root := t.TempDir()
+		store, err := main.NewFsStore(root)
+		require.NoError(err)
+
+		ctx := context.Background()
+		data := randomData(t)
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		// Truncated as if the node crashed.
+		err = os.Truncate(store.Resolve(DescriptionFoo), 64)
+		require.NoError(err)
+
+		_, _, err = store.Get(ctx, DescriptionFoo)
+		require.ErrorIs(err, main.ErrCorrupted)
+
+		_, err = store.Head(ctx, DescriptionFoo)
+		require.ErrorIs(err, main.ErrNotExist)
+		require.NoDirExists(filepath.Join(root, DescriptionFoo.Name))
+		require.FileExists(filepath.Join(root, ".quarantine", DescriptionFoo.Name, DescriptionFoo.Version, DescriptionFoo.Hash))
+
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		received, err := readAll(ctx, store, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(data, received)
+	})
+
+	t.Run("file is verified once until it is modified", func(t *testing.T) {
+		require := require.New(t)
+
+		store, err := main.NewFsStore(t.TempDir())
+		require.NoError(err)
+
+		ctx := context.Background()
+		data := randomData(t)
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		_, err = readAll(ctx, store, DescriptionFoo)
+		require.NoError(err)
+
+		// Overwritten with the same size and modification time so
+		// the verification made above is reused.
+		p := store.Resolve(DescriptionFoo)
+		info, err := os.Stat(p)
+		require.NoError(err)
+
+		corrupted := bytes.Repeat([]byte{0}, len(data))
+		err = os.WriteFile(p, corrupted, 0644)
+		require.NoError(err)
+		err = os.Chtimes(p, info.ModTime(), info.ModTime())
+		require.NoError(err)
+
+		received, err := readAll(ctx, store, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(corrupted, received)
+
+		// Modified file is verified again.
+		mod_time := info.ModTime().Add(time.Second)
+		err = os.Chtimes(p, mod_time, mod_time)
+		require.NoError(err)
+
+		_, _, err = store.Get(ctx, DescriptionFoo)
+		require.ErrorIs(err, main.ErrCorrupted)
+	})
+
+	t.Run("file without digest is not verified", func(t *testing.T) {
+		require := require.New(t)
+
+		store, err := main.NewFsStore(t.TempDir())
+		require.NoError(err)
+
+		p := store.Resolve(DescriptionFoo)
+		err = os.MkdirAll(filepath.Dir(p), 0755)
+		require.NoError(err)
+
+		data := randomData(t)
+		err = os.WriteFile(p, data, 0644)
+		require.NoError(err)
+
+		received, err := readAll(context.Background(), store, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(data, received)
+	})
+}
+
+func TestFsStoreMaxSize(t *testing.T) {
+	descs := []main.Description{
+		{Name: "foo", Version: "bar", Hash: "a"},
+		{Name: "foo", Version: "bar", Hash: "b"},
+		{Name: "foo", Version: "baz", Hash: "c"},
+	}
+
+	t.Run("least recently accessed file is evicted", func(t *testing.T) {
+		require := require.New(t)
+
+		root := t.TempDir()
+		store, err := main.NewFsStore(root, main.WithMaxSize(300))
+		require.NoError(err)
+		defer store.Close()
+
+		ctx := context.Background()
+		for _, desc := range descs[:2] {
+			err := store.Put(ctx, desc, bytes.NewReader(randomData(t)))
+			require.NoError(err)
+		}
+
+		_, err = store.Head(ctx, descs[0])
+		require.NoError(err)
+
+		err = store.Put(ctx, descs[2], bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		require.Eventually(func() bool {
+			_, err := os.Stat(store.Resolve(descs[1]))
+			return os.IsNotExist(err)
+		}, time.Second, time.Millisecond*10)
+		require.FileExists(store.Resolve(descs[0]))
+		require.FileExists(store.Resolve(descs[2]))
+	})
+
+	t.Run("empty directories are removed on eviction", func(t *testing.T) {
+		require := require.New(t)
+
+		root := t.TempDir()
+		store, err := main.NewFsStore(root, main.WithMaxSize(200))
+		require.NoError(err)
+		defer store.Close()
+
+		ctx := context.Background()
+		err = store.Put(ctx, descs[2], bytes.NewReader(randomData(t)))
+		require.NoError(err)
+		err = store.Put(ctx, descs[0], bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		require.Eventually(func() bool {
+			_, err := os.Stat(filepath.Join(root, "foo", "baz"))
+			return os.IsNotExist(err)
+		}, time.Second, time.Millisecond*10)
+		require.FileExists(store.Resolve(descs[0]))
+	})
+
+	t.Run("existing files are evicted in modification order", func(t *testing.T) {
+		require := require.New(t)
+
+		root := t.TempDir()
+		{
+			store, err := main.NewFsStore(root)
+			require.NoError(err)
+
+			t0 := time.Now()
+			for i, desc := range descs {
+				err := store.Put(context.Background(), desc, bytes.NewReader(randomData(t)))
+				require.NoError(err)
+
+				mod_time := t0.Add(time.Duration(i-len(descs)) * time.Hour)
+				if i == 0 {
+					mod_time = t0
+				}
+				err = os.Chtimes(store.Resolve(desc), mod_time, mod_time)
+				require.NoError(err)
+			}
+
+			err = store.Close()
+			require.NoError(err)
+		}
+
+		store, err := main.NewFsStore(root, main.WithMaxSize(300))
+		require.NoError(err)
+		defer store.Close()
+
+		require.Eventually(func() bool {
+			_, err := os.Stat(store.Resolve(descs[1]))
+			return os.IsNotExist(err)
+		}, time.Second, time.Millisecond*10)
+		require.FileExists(store.Resolve(descs[0]))
+		require.FileExists(store.Resolve(descs[2]))
+	})
+}
+
 func TestNewFsStore(t *testing.T) {
 	if runtime.GOOS == "windows" {
 		// TODO:
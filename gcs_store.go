@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+type gcsStore struct {
+	client *storage.Client
+	bucket *storage.BucketHandle
+	prefix string
+
+	// Size of the chunks of resumable uploads.
+	// Uploads smaller than this are sent in a single request.
+	chunk_size int
+}
+
+type gcsOption func(s *gcsStore)
+
+func WithGcsPrefix(p string) gcsOption {
+	return func(s *gcsStore) {
+		s.prefix = strings.Trim(p, "/")
+	}
+}
+
+// WithGcsChunkSize sets the size of the chunks of resumable uploads.
+// Each chunk is buffered in memory and retried on failure.
+func WithGcsChunkSize(size int) gcsOption {
+	return func(s *gcsStore) {
+		s.chunk_size = size
+	}
+}
+
+func NewGcsStore(client *storage.Client, bucket string, opts ...gcsOption) (*gcsStore, error) {
+	s := &gcsStore{client: client, chunk_size: googleapi.DefaultUploadChunkSize}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if bucket == "" {
+		return nil, errors.New("bucket must be specified")
+	}
+	if s.chunk_size <= 0 {
+		return nil, errors.New("chunk size must be positive")
+	}
+
+	s.bucket = client.Bucket(bucket)
+	if _, err := s.bucket.Attrs(context.Background()); err != nil {
+		if errors.Is(err, storage.ErrBucketNotExist) {
+			return nil, fmt.Errorf("bucket not found: %s", bucket)
+		}
+		return nil, fmt.Errorf("check bucket: %w", err)
+	}
+
+	return s, nil
+}
+
+// newGcsStoreFromConfig creates a store from options such as:
+//
+//	gcs:,bucket=vcpkg,prefix=cache,credentials_file=/etc/vcpkg-cache-http/key.json
+func newGcsStoreFromConfig(conf *StoreConfig) (*gcsStore, error) {
+	client_opts := []option.ClientOption{}
+	if p, ok := conf.Opts["credentials_file"]; ok {
+		client_opts = append(client_opts, option.WithCredentialsFile(p))
+	}
+
+	opts := []gcsOption{WithGcsPrefix(conf.Opts["prefix"])}
+	if v, ok := conf.Opts["chunk_size"]; ok {
+		size, err := parseSize(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for option chunk_size: %w", err)
+		}
+
+		opts = append(opts, WithGcsChunkSize(int(size)))
+	}
+
+	// Credentials are found by Application Default Credentials if not given.
+	// `STORAGE_EMULATOR_HOST` environment variable is respected.
+	client, err := storage.NewClient(context.Background(), client_opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create GCS client: %w", err)
+	}
+
+	store, err := NewGcsStore(client, conf.Opts["bucket"], opts...)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (s *gcsStore) Resolve(desc Description) string {
+	return path.Join(s.prefix, desc.Name, desc.Version, desc.Hash)
+}
+
+func gcsError(err error) error {
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("%w: %s", ErrNotExist, err.Error())
+	}
+
+	return err
+}
+
+// Get reads the attributes of the object and the object is read on the first read
+// so it is not downloaded twice when it is seeked before the read, e.g. by range requests.
+func (s *gcsStore) Get(ctx context.Context, desc Description) (io.ReadCloser, Info, error) {
+	attrs, err := s.bucket.Object(s.Resolve(desc)).Attrs(ctx)
+	if err != nil {
+		return nil, Info{}, gcsError(err)
+	}
+
+	// Object is pinned to the generation being read
+	// so seeking does not mix up the contents if the object is replaced.
+	obj := s.bucket.Object(s.Resolve(desc)).Generation(attrs.Generation)
+	return &rangeReader{
+		open: func(off int64) (io.ReadCloser, error) {
+			r, err := obj.NewRangeReader(ctx, off, -1)
+			if err != nil {
+				return nil, fmt.Errorf("read object from %d: %w", off, gcsError(err))
+			}
+
+			return r, nil
+		},
+		size: attrs.Size,
+	}, Info{
+		Size:     attrs.Size,
+		ModTime:  attrs.Updated,
+		Seekable: true,
+	}, nil
+}
+
+func (s *gcsStore) Head(ctx context.Context, desc Description) (Info, error) {
+	attrs, err := s.bucket.Object(s.Resolve(desc)).Attrs(ctx)
+	if err != nil {
+		return Info{}, gcsError(err)
+	}
+
+	return Info{Size: attrs.Size, ModTime: attrs.Updated, Seekable: true}, nil
+}
+
+// ReadAt reads the part of the object by a ranged read.
+func (s *gcsStore) ReadAt(ctx context.Context, desc Description, p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	r, err := s.bucket.Object(s.Resolve(desc)).NewRangeReader(ctx, off, int64(len(p)))
+	if err != nil {
+		var err_api *googleapi.Error
+		if errors.As(err, &err_api) && err_api.Code == http.StatusRequestedRangeNotSatisfiable {
+			// The object ends before the offset.
+			return 0, io.EOF
+		}
+		return 0, gcsError(err)
+	}
+	defer r.Close()
+
+	n, err := io.ReadFull(r, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// Put uploads the artifact by a resumable upload so a failure of a chunk is retried
+// without uploading the whole artifact again.
+// The object is created only if it still does not exist at the end of the upload
+// so concurrent uploads do not overwrite each other.
+func (s *gcsStore) Put(ctx context.Context, desc Description, r io.Reader) error {
+	obj := s.bucket.Object(s.Resolve(desc))
+	if _, err := obj.Attrs(ctx); err == nil {
+		return ErrExist
+	} else if !errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("get object attributes: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := obj.If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+	w.ChunkSize = s.chunk_size
+	w.ContentType = "application/zip"
+
+	if _, err := io.Copy(w, r); err != nil {
+		// Upload is aborted by cancellation.
+		cancel()
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		var err_api *googleapi.Error
+		if errors.As(err, &err_api) && err_api.Code == http.StatusPreconditionFailed {
+			return ErrExist
+		}
+		return fmt.Errorf("upload object: %w", err)
+	}
+
+	return nil
+}
+
+func (s *gcsStore) Delete(ctx context.Context, desc Description) error {
+	if err := s.bucket.Object(s.Resolve(desc)).Delete(ctx); err != nil {
+		return gcsError(err)
+	}
+
+	return nil
+}
+
+func (s *gcsStore) List(ctx context.Context, fn func(entry Entry) error) error {
+	prefix := objectListPrefix(s.prefix)
+
+	query := &storage.Query{Prefix: prefix}
+	if err := query.SetAttrSelection([]string{"Name", "Size", "Updated"}); err != nil {
+		return err
+	}
+
+	objs := s.bucket.Objects(ctx, query)
+	for {
+		attrs, err := objs.Next()
+		if errors.Is(err, iterator.Done) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("list objects: %w", err)
+		}
+
+		desc, ok := unresolveObjectKey(prefix, attrs.Name)
+		if !ok {
+			continue
+		}
+
+		err = fn(Entry{
+			Description: desc,
+			Size:        attrs.Size,
+			ModTime:     attrs.Updated,
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *gcsStore) Close() error {
+	return s.client.Close()
+}
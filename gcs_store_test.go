@@ -0,0 +1,217 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+	main "github.com/lesomnus/vcpkg-cache-http"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/api/option"
+)
+
+func NewTestGcsServer(t *testing.T) *fakestorage.Server {
+	require := require.New(t)
+
+	server, err := fakestorage.NewServerWithOptions(fakestorage.Options{
+		Scheme: "http",
+		Host:   "127.0.0.1",
+	})
+	require.NoError(err)
+	t.Cleanup(server.Stop)
+
+	server.CreateBucketWithOpts(fakestorage.CreateBucketOpts{Name: "vcpkg"})
+	return server
+}
+
+type GcsStoreSetup struct{}
+
+func (s *GcsStoreSetup) New(t *testing.T) (main.Store, error) {
+	return main.NewGcsStore(NewTestGcsServer(t).Client(), "vcpkg", main.WithGcsPrefix("cache"))
+}
+
+func TestGcsStoreSuite(t *testing.T) {
+	suite.Run(t, &StoreTestSuite{Store: &GcsStoreSetup{}})
+}
+
+func TestNewGcsStore(t *testing.T) {
+	t.Run("bucket must be specified", func(t *testing.T) {
+		require := require.New(t)
+
+		_, err := main.NewGcsStore(NewTestGcsServer(t).Client(), "")
+		require.ErrorContains(err, "bucket")
+	})
+
+	t.Run("bucket must exist", func(t *testing.T) {
+		require := require.New(t)
+
+		_, err := main.NewGcsStore(NewTestGcsServer(t).Client(), "not-exists")
+		require.ErrorContains(err, "bucket not found")
+	})
+
+	t.Run("chunk size must be positive", func(t *testing.T) {
+		require := require.New(t)
+
+		_, err := main.NewGcsStore(NewTestGcsServer(t).Client(), "vcpkg", main.WithGcsChunkSize(0))
+		require.ErrorContains(err, "chunk size")
+	})
+}
+
+func TestGcsStore(t *testing.T) {
+	t.Run("large artifact is uploaded by chunks", func(t *testing.T) {
+		require := require.New(t)
+
+		store, err := main.NewGcsStore(NewTestGcsServer(t).Client(), "vcpkg", main.WithGcsChunkSize(256*1024))
+		require.NoError(err)
+
+		data := bytes.Repeat(randomData(t), 5000)
+
+		ctx := context.Background()
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		received, err := readAll(ctx, store, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(data, received)
+
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.ErrorIs(err, main.ErrExist)
+	})
+
+	t.Run("artifact is read from the offset", func(t *testing.T) {
+		require := require.New(t)
+
+		store, err := main.NewGcsStore(NewTestGcsServer(t).Client(), "vcpkg")
+		require.NoError(err)
+
+		data := randomData(t)
+
+		ctx := context.Background()
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		r, _, err := store.Get(ctx, DescriptionFoo)
+		require.NoError(err)
+		defer r.Close()
+
+		seeker, ok := r.(io.ReadSeeker)
+		require.True(ok)
+
+		_, err = seeker.Seek(-28, io.SeekEnd)
+		require.NoError(err)
+
+		received, err := io.ReadAll(seeker)
+		require.NoError(err)
+		require.Equal(data[100:], received)
+	})
+
+	t.Run("object is downloaded once if it is seeked before the read", func(t *testing.T) {
+		require := require.New(t)
+
+		server := NewTestGcsServer(t)
+
+		// Counts downloads of the objects which are not through JSON API.
+		downloads := int32(0)
+		client, err := storage.NewClient(context.Background(), option.WithHTTPClient(&http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				if req.Method == http.MethodGet && !strings.HasPrefix(req.URL.Path, "/storage/v1/") {
+					atomic.AddInt32(&downloads, 1)
+				}
+				return server.HTTPClient().Transport.RoundTrip(req)
+			}),
+		}))
+		require.NoError(err)
+
+		store, err := main.NewGcsStore(client, "vcpkg")
+		require.NoError(err)
+
+		data := randomData(t)
+
+		ctx := context.Background()
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		r, _, err := store.Get(ctx, DescriptionFoo)
+		require.NoError(err)
+		defer r.Close()
+
+		// As `http.ServeContent` does.
+		seeker := r.(io.ReadSeeker)
+		_, err = seeker.Seek(0, io.SeekEnd)
+		require.NoError(err)
+		_, err = seeker.Seek(0, io.SeekStart)
+		require.NoError(err)
+
+		received, err := io.ReadAll(seeker)
+		require.NoError(err)
+		require.Equal(data, received)
+		require.Equal(int32(1), atomic.LoadInt32(&downloads))
+	})
+
+	t.Run("get, head and list report the same modification time", func(t *testing.T) {
+		require := require.New(t)
+
+		store, err := main.NewGcsStore(NewTestGcsServer(t).Client(), "vcpkg")
+		require.NoError(err)
+
+		ctx := context.Background()
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		r, info_get, err := store.Get(ctx, DescriptionFoo)
+		require.NoError(err)
+		r.Close()
+
+		info_head, err := store.Head(ctx, DescriptionFoo)
+		require.NoError(err)
+		require.True(info_get.ModTime.Equal(info_head.ModTime))
+
+		err = store.List(ctx, func(entry main.Entry) error {
+			require.True(info_head.ModTime.Equal(entry.ModTime))
+			return nil
+		})
+		require.NoError(err)
+	})
+
+	t.Run("objects not in the layout are not listed", func(t *testing.T) {
+		require := require.New(t)
+
+		server := NewTestGcsServer(t)
+		store, err := main.NewGcsStore(server.Client(), "vcpkg", main.WithGcsPrefix("cache"))
+		require.NoError(err)
+
+		ctx := context.Background()
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		for _, name := range []string{"cache/foo", "other/foo/bar/baz"} {
+			w := server.Client().Bucket("vcpkg").Object(name).NewWriter(ctx)
+			_, err = w.Write([]byte("foo"))
+			require.NoError(err)
+			require.NoError(w.Close())
+		}
+
+		entries := []main.Entry{}
+		err = store.List(ctx, func(entry main.Entry) error {
+			entries = append(entries, entry)
+			return nil
+		})
+		require.NoError(err)
+		require.Len(entries, 1)
+		require.Equal(DescriptionFoo, entries[0].Description)
+		require.Equal(int64(128), entries[0].Size)
+	})
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// httpStore persists artifacts to an upstream HTTP server by PUT, GET, HEAD and DELETE,
+// such as a generic repository of Artifactory or a WebDAV server.
+type httpStore struct {
+	upstream string
+	client   *http.Client
+
+	// Creates parent collections by MKCOL before PUT as WebDAV servers require.
+	dav bool
+
+	// Path of the collection where the placeholders of `upstream` start, e.g. "/dav/" of "http://host/dav/{name}/{version}/{sha}".
+	// Collections under it are created by MKCOL.
+	root string
+
+	// Value of "Authorization" header.
+	authorization string
+}
+
+type httpOption func(s *httpStore)
+
+func WithHttpStoreClient(c *http.Client) httpOption {
+	return func(s *httpStore) {
+		s.client = c
+	}
+}
+
+// WithDav makes the store create parent collections of an artifact by MKCOL before it is uploaded.
+func WithDav() httpOption {
+	return func(s *httpStore) {
+		s.dav = true
+	}
+}
+
+func WithBasicAuth(username string, password string) httpOption {
+	return func(s *httpStore) {
+		s.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	}
+}
+
+func WithBearerToken(token string) httpOption {
+	return func(s *httpStore) {
+		s.authorization = "Bearer " + token
+	}
+}
+
+// NewHttpStore creates a store that persists artifacts to the `upstream`.
+// `upstream` is an URL that may contain `{name}`, `{version}` and `{sha}` placeholders.
+// If there is no placeholder, artifacts are stored at `{upstream}/{name}/{version}/{sha}`.
+func NewHttpStore(upstream string, opts ...httpOption) (*httpStore, error) {
+	s := &httpStore{upstream: upstream}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.upstream == "" {
+		return nil, errors.New("upstream must be specified")
+	}
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+
+	s.upstream = expandUrlTemplate(s.upstream)
+	if _, err := url.Parse(resolveUrlTemplate(s.upstream, Description{})); err != nil {
+		return nil, fmt.Errorf("invalid upstream URL: %w", err)
+	}
+
+	static := s.upstream[:strings.Index(s.upstream, "{")]
+	root, err := url.Parse(static[:strings.LastIndex(static, "/")+1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream URL: %w", err)
+	}
+	s.root = root.Path
+
+	return s, nil
+}
+
+// newHttpStoreFromConfig creates a store from options such as:
+//
+//	http:https://artifactory.example.com/artifactory/vcpkg/{name}/{version}/{sha}.zip,token=s3cret
+//	http:http://nginx:8080/vcpkg,dav,username=ci,password=s3cret
+func newHttpStoreFromConfig(conf *StoreConfig) (*httpStore, error) {
+	opts := []httpOption{}
+	if dav, err := conf.Bool("dav"); err != nil {
+		return nil, err
+	} else if dav {
+		opts = append(opts, WithDav())
+	}
+
+	username, has_username := conf.Opts["username"]
+	token, has_token := conf.Opts["token"]
+	if has_username && has_token {
+		return nil, errors.New("only one of username and token can be given")
+	}
+	if has_username {
+		opts = append(opts, WithBasicAuth(username, conf.Opts["password"]))
+	}
+	if has_token {
+		opts = append(opts, WithBearerToken(token))
+	}
+
+	return NewHttpStore(conf.Path, opts...)
+}
+
+func (s *httpStore) Resolve(desc Description) string {
+	return resolveUrlTemplate(s.upstream, desc)
+}
+
+func (s *httpStore) do(ctx context.Context, method string, target string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, fmt.Errorf("create upstream request: %w", err)
+	}
+	if s.authorization != "" {
+		req.Header.Set("Authorization", s.authorization)
+	}
+	if method == http.MethodPut {
+		req.Header.Set("Content-Type", "application/zip")
+		// Servers supporting conditional requests reject the upload if the artifact is created meanwhile.
+		req.Header.Set("If-None-Match", "*")
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request upstream: %w", err)
+	}
+
+	return res, nil
+}
+
+// fetch sends the request and returns the response if it is successful.
+func (s *httpStore) fetch(ctx context.Context, method string, desc Description) (*http.Response, error) {
+	res, err := s.do(ctx, method, s.Resolve(desc), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusAccepted:
+		return res, nil
+
+	case http.StatusNotFound:
+		res.Body.Close()
+		return nil, fmt.Errorf("%w: upstream responds %s", ErrNotExist, res.Status)
+
+	default:
+		res.Body.Close()
+		return nil, fmt.Errorf("upstream responds %s", res.Status)
+	}
+}
+
+func (s *httpStore) Get(ctx context.Context, desc Description) (io.ReadCloser, Info, error) {
+	res, err := s.fetch(ctx, http.MethodGet, desc)
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	return res.Body, upstreamInfo(res), nil
+}
+
+func (s *httpStore) Head(ctx context.Context, desc Description) (Info, error) {
+	res, err := s.fetch(ctx, http.MethodHead, desc)
+	if err != nil {
+		return Info{}, err
+	}
+
+	res.Body.Close()
+	return upstreamInfo(res), nil
+}
+
+func (s *httpStore) Put(ctx context.Context, desc Description, r io.Reader) error {
+	if _, err := s.Head(ctx, desc); err == nil {
+		return ErrExist
+	} else if !errors.Is(err, ErrNotExist) {
+		return fmt.Errorf("check upstream: %w", err)
+	}
+
+	tgt := s.Resolve(desc)
+	if s.dav {
+		if err := s.mkcol(ctx, tgt); err != nil {
+			return err
+		}
+	}
+
+	res, err := s.do(ctx, http.MethodPut, tgt, r)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+
+	case http.StatusPreconditionFailed:
+		return ErrExist
+
+	default:
+		return fmt.Errorf("upstream responds %s", res.Status)
+	}
+}
+
+// mkcol creates the collections from the root of the upstream to the parent of `tgt`,
+// e.g. `{name}` and `{version}` in the default layout.
+// The root must exist.
+func (s *httpStore) mkcol(ctx context.Context, tgt string) error {
+	u, err := url.Parse(tgt)
+	if err != nil {
+		return fmt.Errorf("parse target URL: %w", err)
+	}
+	if !strings.HasPrefix(u.Path, s.root) {
+		return nil
+	}
+
+	dir := s.root
+	dirs := []string{}
+	segments := strings.Split(strings.TrimPrefix(u.Path, s.root), "/")
+	for _, segment := range segments[:len(segments)-1] {
+		if segment == "" {
+			continue
+		}
+
+		dir += segment + "/"
+		dirs = append(dirs, dir)
+	}
+
+	for _, dir := range dirs {
+		u.Path = dir
+		u.RawPath = ""
+
+		res, err := s.do(ctx, "MKCOL", u.String(), nil)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+
+		// 405 if the collection already exists.
+		if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusMethodNotAllowed {
+			return fmt.Errorf("create collection %s: upstream responds %s", dir, res.Status)
+		}
+	}
+
+	return nil
+}
+
+func (s *httpStore) Delete(ctx context.Context, desc Description) error {
+	res, err := s.fetch(ctx, http.MethodDelete, desc)
+	if err != nil {
+		return err
+	}
+
+	res.Body.Close()
+	return nil
+}
+
+func (s *httpStore) Close() error {
+	return nil
+}
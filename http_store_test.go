@@ -0,0 +1,222 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	main "github.com/lesomnus/vcpkg-cache-http"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/net/webdav"
+)
+
+func NewTestDavServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(&webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	})
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+type HttpStoreSetup struct{}
+
+func (s *HttpStoreSetup) New(t *testing.T) (main.Store, error) {
+	return main.NewHttpStore(NewTestDavServer(t).URL+"/dav", main.WithDav())
+}
+
+func TestHttpStoreSuite(t *testing.T) {
+	suite.Run(t, &StoreTestSuite{Store: &HttpStoreSetup{}})
+}
+
+// genericRepo stores artifacts at any path like a generic repository of Artifactory.
+type genericRepo struct {
+	mutex sync.Mutex
+	files map[string][]byte
+
+	authorization string
+}
+
+func (h *genericRepo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != h.authorization {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	data, ok := h.files[r.URL.Path]
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+
+	case http.MethodPut:
+		if ok && r.Header.Get("If-None-Match") == "*" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		h.files[r.URL.Path] = data
+		w.WriteHeader(http.StatusCreated)
+
+	case http.MethodDelete:
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		delete(h.files, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestHttpStore(t *testing.T) {
+	t.Run("artifacts are stored at the URL of the template", func(t *testing.T) {
+		require := require.New(t)
+
+		repo := &genericRepo{files: map[string][]byte{}, authorization: "Bearer s3cret"}
+		server := httptest.NewServer(repo)
+		defer server.Close()
+
+		store, err := main.NewHttpStore(server.URL+"/vcpkg/{sha}.zip", main.WithBearerToken("s3cret"))
+		require.NoError(err)
+
+		data := randomData(t)
+
+		ctx := context.Background()
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+		require.Equal(data, repo.files["/vcpkg/"+DescriptionFoo.Hash+".zip"])
+
+		info, err := store.Head(ctx, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(int64(len(data)), info.Size)
+
+		received, err := readAll(ctx, store, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(data, received)
+	})
+
+	t.Run("credentials are sent", func(t *testing.T) {
+		require := require.New(t)
+
+		repo := &genericRepo{files: map[string][]byte{}, authorization: "Basic Y2k6czNjcmV0"}
+		server := httptest.NewServer(repo)
+		defer server.Close()
+
+		ctx := context.Background()
+
+		store, err := main.NewHttpStore(server.URL, main.WithBasicAuth("ci", "s3cret"))
+		require.NoError(err)
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+		require.Contains(repo.files, DescriptionFoo.String())
+
+		store, err = main.NewHttpStore(server.URL)
+		require.NoError(err)
+		_, err = store.Head(ctx, DescriptionFoo)
+		require.ErrorContains(err, "401")
+		require.NotErrorIs(err, main.ErrNotExist)
+	})
+
+	t.Run("upload of an artifact created meanwhile is conflicted", func(t *testing.T) {
+		require := require.New(t)
+
+		// Artifact is not found by HEAD but created when it is uploaded.
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPut && r.Header.Get("If-None-Match") == "*" {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		store, err := main.NewHttpStore(server.URL)
+		require.NoError(err)
+
+		err = store.Put(context.Background(), DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.ErrorIs(err, main.ErrExist)
+	})
+
+	t.Run("upload fails without collections unless dav is enabled", func(t *testing.T) {
+		require := require.New(t)
+
+		server := NewTestDavServer(t)
+		store, err := main.NewHttpStore(server.URL + "/dav")
+		require.NoError(err)
+
+		err = store.Put(context.Background(), DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.ErrorContains(err, "upstream responds")
+	})
+
+	t.Run("collections under the root of the template are created", func(t *testing.T) {
+		require := require.New(t)
+
+		dav := &webdav.Handler{
+			Prefix:     "/dav",
+			FileSystem: webdav.NewMemFS(),
+			LockSystem: webdav.NewMemLS(),
+		}
+
+		mkcols := []string{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "MKCOL" {
+				mkcols = append(mkcols, r.URL.Path)
+			}
+			dav.ServeHTTP(w, r)
+		}))
+		defer server.Close()
+
+		// Root of the template must exist.
+		req, err := http.NewRequest("MKCOL", server.URL+"/dav/vcpkg/", nil)
+		require.NoError(err)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(err)
+		res.Body.Close()
+		require.Equal(http.StatusCreated, res.StatusCode)
+
+		store, err := main.NewHttpStore(server.URL+"/dav/vcpkg/{name}/{version}/{sha}/package.zip", main.WithDav())
+		require.NoError(err)
+
+		ctx := context.Background()
+		data := randomData(t)
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+		require.Equal([]string{"/dav/vcpkg/", "/dav/vcpkg/foo/", "/dav/vcpkg/foo/bar/", "/dav/vcpkg/foo/bar/baz/"}, mkcols)
+
+		received, err := readAll(ctx, store, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(data, received)
+	})
+
+	t.Run("upstream must be specified", func(t *testing.T) {
+		require := require.New(t)
+
+		_, err := main.NewHttpStore("")
+		require.ErrorContains(err, "upstream")
+	})
+}
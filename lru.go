@@ -0,0 +1,94 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+type lruEntry struct {
+	key  string
+	size int64
+}
+
+// lruIndex tracks sizes of entries in order of recent access.
+type lruIndex struct {
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // Front is the most recently used one.
+	size    int64
+}
+
+func newLruIndex() *lruIndex {
+	return &lruIndex{
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+// Add inserts or updates the entry as the most recently used one.
+func (l *lruIndex) Add(key string, size int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if e, ok := l.entries[key]; ok {
+		entry := e.Value.(*lruEntry)
+		l.size += size - entry.size
+		entry.size = size
+		l.order.MoveToFront(e)
+		return
+	}
+
+	l.entries[key] = l.order.PushFront(&lruEntry{key: key, size: size})
+	l.size += size
+}
+
+// Touch marks the entry as the most recently used one.
+// It returns false if the entry does not exist.
+func (l *lruIndex) Touch(key string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok {
+		return false
+	}
+
+	l.order.MoveToFront(e)
+	return true
+}
+
+func (l *lruIndex) Remove(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok {
+		return
+	}
+
+	l.size -= e.Value.(*lruEntry).size
+	l.order.Remove(e)
+	delete(l.entries, key)
+}
+
+// Oldest returns the least recently used entry.
+func (l *lruIndex) Oldest() (string, int64, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	e := l.order.Back()
+	if e == nil {
+		return "", 0, false
+	}
+
+	entry := e.Value.(*lruEntry)
+	return entry.key, entry.size, true
+}
+
+// Size returns total size of the entries.
+func (l *lruIndex) Size() int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.size
+}
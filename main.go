@@ -63,11 +63,64 @@ func main() {
 		handler.IsReadable = false
 		l.Info().Msg("download disabled")
 	}
+	if conf.AllowDelete {
+		handler.IsDeletable = true
+		l.Info().Msg("delete enabled")
+	}
+	if conf.ValidateArchives {
+		handler.IsValidating = true
+		handler.SpoolDir = conf.SpoolDir
+		l.Info().Msg("archive validation enabled")
+	}
+
+	if conf.Metrics {
+		handler.Metrics = NewMetrics(store, time.Duration(conf.MetricsStoreInterval))
+		l.Info().Msg("metrics enabled")
+	}
+
+	if auth, err := NewAuthenticator(conf.Auth); err != nil {
+		l.Fatal().Err(err).Msg("failed to initialize the authentication")
+		return
+	} else if auth != nil {
+		handler.Auth = auth
+		l.Info().Msg("authentication enabled")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if conf.Retention != nil {
+		retention, err := NewRetention(store, conf.Retention)
+		if err != nil {
+			l.Fatal().Err(err).Msg("failed to initialize the retention")
+			return
+		}
+		retention.Log = l
+
+		interval := time.Duration(conf.Retention.Interval)
+		if interval <= 0 {
+			interval = time.Hour
+		}
+
+		l.Info().
+			Dur("max_age", retention.MaxAge).
+			Dur("interval", interval).
+			Int("overrides", len(retention.Overrides)).
+			Msg("retention enabled")
+		go retention.Run(ctx, interval)
+	}
+
+	tls_conf, err := NewTlsConfig(conf)
+	if err != nil {
+		l.Fatal().Err(err).Msg("failed to initialize TLS")
+		return
+	}
 
 	addr := fmt.Sprintf("%s:%d", conf.Host, conf.Port)
 	server := &http.Server{
-		Addr:    addr,
-		Handler: handler,
+		Addr:      addr,
+		Handler:   handler,
+		TLSConfig: tls_conf,
 	}
 
 	server_closed := make(chan struct{})
@@ -91,8 +144,14 @@ func main() {
 		}
 	}()
 
-	l.Info().Str("addr", addr).Msg("start server")
-	if err := server.ListenAndServe(); err != nil {
+	l.Info().Str("addr", addr).Bool("tls", tls_conf != nil).Msg("start server")
+	if tls_conf == nil {
+		err = server.ListenAndServe()
+	} else {
+		// Certificates are already loaded in the TLS config.
+		err = server.ListenAndServeTLS("", "")
+	}
+	if err != nil {
 		if errors.Is(err, http.ErrServerClosed) {
 			l.Info().Msg("server closed gracefully")
 		} else {
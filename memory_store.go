@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	desc     Description
+	data     []byte
+	mod_time time.Time
+	digest   string
+}
+
+func (e *memoryEntry) info() Info {
+	return Info{
+		Size:     int64(len(e.data)),
+		ModTime:  e.mod_time,
+		Digest:   e.digest,
+		Seekable: true,
+	}
+}
+
+// memoryReader reads the artifact held in memory.
+// The data is never modified once stored so it is safe to read after the entry is evicted.
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (r *memoryReader) Close() error {
+	return nil
+}
+
+// memoryStoreDefaultMaxSize bounds the store unless the limit is given
+// so the server is not killed by out of memory, e.g. when the store is used as a tier.
+const memoryStoreDefaultMaxSize = 1 << 30
+
+// memoryStore holds artifacts in memory, e.g. for ephemeral CI jobs or as a hot tier.
+type memoryStore struct {
+	mutex   sync.Mutex
+	entries map[string]*memoryEntry
+
+	// Total size of the artifacts in the store is limited to `max_size` if it is not 0.
+	// It is `memoryStoreDefaultMaxSize` by default.
+	// Artifacts are evicted in least recently accessed order.
+	max_size int64
+	index    *lruIndex
+}
+
+type memoryOption func(s *memoryStore)
+
+// WithMemoryMaxSize limits total size of the artifacts in the store.
+// Least recently accessed artifacts are evicted if the total size exceeds the limit.
+// The store is unbounded if it is 0.
+func WithMemoryMaxSize(size int64) memoryOption {
+	return func(s *memoryStore) {
+		s.max_size = size
+	}
+}
+
+func NewMemoryStore(opts ...memoryOption) (*memoryStore, error) {
+	s := &memoryStore{
+		entries:  map[string]*memoryEntry{},
+		max_size: memoryStoreDefaultMaxSize,
+		index:    newLruIndex(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.max_size < 0 {
+		return nil, errors.New("max size must not be negative")
+	}
+
+	return s, nil
+}
+
+func (s *memoryStore) find(desc Description) (*memoryEntry, bool) {
+	key := desc.String()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, ok := s.entries[key]
+	if ok {
+		s.index.Touch(key)
+	}
+
+	return entry, ok
+}
+
+func (s *memoryStore) Get(ctx context.Context, desc Description) (io.ReadCloser, Info, error) {
+	entry, ok := s.find(desc)
+	if !ok {
+		return nil, Info{}, ErrNotExist
+	}
+
+	return &memoryReader{bytes.NewReader(entry.data)}, entry.info(), nil
+}
+
+func (s *memoryStore) Head(ctx context.Context, desc Description) (Info, error) {
+	entry, ok := s.find(desc)
+	if !ok {
+		return Info{}, ErrNotExist
+	}
+
+	return entry.info(), nil
+}
+
+func (s *memoryStore) Put(ctx context.Context, desc Description, r io.Reader) error {
+	key := desc.String()
+
+	s.mutex.Lock()
+	_, ok := s.entries[key]
+	s.mutex.Unlock()
+	if ok {
+		return ErrExist
+	}
+
+	if s.max_size > 0 {
+		// Read one more byte to tell if the artifact exceeds the limit.
+		r = io.LimitReader(r, s.max_size+1)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if s.max_size > 0 && int64(len(data)) > s.max_size {
+		return fmt.Errorf("%w: larger than the max size of the store %d", ErrTooLarge, s.max_size)
+	}
+
+	digest := sha256.Sum256(data)
+	entry := &memoryEntry{
+		desc:     desc,
+		data:     data,
+		mod_time: time.Now(),
+		digest:   hex.EncodeToString(digest[:]),
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// It can be stored by another request while it is being read.
+	if _, ok := s.entries[key]; ok {
+		return ErrExist
+	}
+
+	s.entries[key] = entry
+	s.index.Add(key, int64(len(data)))
+	s.evict()
+
+	return nil
+}
+
+// evict removes least recently accessed artifacts until the total size is under the limit.
+// It must be called with the lock held.
+func (s *memoryStore) evict() {
+	if s.max_size == 0 {
+		return
+	}
+
+	for s.index.Size() > s.max_size {
+		key, _, ok := s.index.Oldest()
+		if !ok {
+			return
+		}
+
+		s.index.Remove(key)
+		delete(s.entries, key)
+	}
+}
+
+func (s *memoryStore) List(ctx context.Context, fn func(entry Entry) error) error {
+	s.mutex.Lock()
+	keys := make([]string, 0, len(s.entries))
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Listed in the same order as the files in `fsStore`.
+	entries := make([]Entry, 0, len(keys))
+	for _, key := range keys {
+		entry := s.entries[key]
+		entries = append(entries, Entry{
+			Description: entry.desc,
+			Size:        int64(len(entry.data)),
+			ModTime:     entry.mod_time,
+			Digest:      entry.digest,
+		})
+	}
+	s.mutex.Unlock()
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, desc Description) error {
+	key := desc.String()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.entries[key]; !ok {
+		return ErrNotExist
+	}
+
+	s.index.Remove(key)
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.entries = map[string]*memoryEntry{}
+	s.index = newLruIndex()
+	return nil
+}
@@ -0,0 +1,128 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	main "github.com/lesomnus/vcpkg-cache-http"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+func NewTestMemoryStore(t *testing.T) (main.Store, error) {
+	return main.NewMemoryStore()
+}
+
+type MemoryStoreSetup struct{}
+
+func (s *MemoryStoreSetup) New(t *testing.T) (main.Store, error) {
+	return NewTestMemoryStore(t)
+}
+
+func TestMemoryStoreSuite(t *testing.T) {
+	suite.Run(t, &StoreTestSuite{Store: &MemoryStoreSetup{}})
+}
+
+func TestMemoryStore(t *testing.T) {
+	t.Run("least recently accessed artifacts are evicted", func(t *testing.T) {
+		require := require.New(t)
+
+		// Fits 2 artifacts.
+		store, err := main.NewMemoryStore(main.WithMemoryMaxSize(300))
+		require.NoError(err)
+
+		ctx := context.Background()
+		descs := []main.Description{
+			{Name: "foo", Version: "bar", Hash: "a"},
+			{Name: "foo", Version: "bar", Hash: "b"},
+			{Name: "foo", Version: "bar", Hash: "c"},
+		}
+
+		err = store.Put(ctx, descs[0], bytes.NewReader(randomData(t)))
+		require.NoError(err)
+		err = store.Put(ctx, descs[1], bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		_, err = store.Head(ctx, descs[0])
+		require.NoError(err)
+
+		err = store.Put(ctx, descs[2], bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		_, err = store.Head(ctx, descs[0])
+		require.NoError(err)
+		_, err = store.Head(ctx, descs[1])
+		require.ErrorIs(err, main.ErrNotExist)
+		_, err = store.Head(ctx, descs[2])
+		require.NoError(err)
+	})
+
+	t.Run("artifact larger than the max size is rejected", func(t *testing.T) {
+		require := require.New(t)
+
+		store, err := main.NewMemoryStore(main.WithMemoryMaxSize(100))
+		require.NoError(err)
+
+		ctx := context.Background()
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.ErrorIs(err, main.ErrTooLarge)
+
+		_, err = store.Head(ctx, DescriptionFoo)
+		require.ErrorIs(err, main.ErrNotExist)
+	})
+
+	t.Run("artifact is readable after it is evicted", func(t *testing.T) {
+		require := require.New(t)
+
+		store, err := main.NewMemoryStore(main.WithMemoryMaxSize(200))
+		require.NoError(err)
+
+		ctx := context.Background()
+		data := randomData(t)
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		r, _, err := store.Get(ctx, DescriptionFoo)
+		require.NoError(err)
+		defer r.Close()
+
+		err = store.Put(ctx, main.Description{Name: "foo", Version: "bar", Hash: "qux"}, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+		_, err = store.Head(ctx, DescriptionFoo)
+		require.ErrorIs(err, main.ErrNotExist)
+
+		received, err := io.ReadAll(r)
+		require.NoError(err)
+		require.Equal(data, received)
+	})
+
+	t.Run("entries are listed", func(t *testing.T) {
+		require := require.New(t)
+
+		store, err := main.NewMemoryStore()
+		require.NoError(err)
+
+		ctx := context.Background()
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		entries := []main.Entry{}
+		err = store.List(ctx, func(entry main.Entry) error {
+			entries = append(entries, entry)
+			return nil
+		})
+		require.NoError(err)
+		require.Len(entries, 1)
+		require.Equal(DescriptionFoo, entries[0].Description)
+		require.Equal(int64(128), entries[0].Size)
+	})
+
+	t.Run("max size must not be negative", func(t *testing.T) {
+		require := require.New(t)
+
+		_, err := main.NewMemoryStore(main.WithMemoryMaxSize(-1))
+		require.Error(err)
+	})
+}
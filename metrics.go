@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "vcpkg_cache"
+
+type Metrics struct {
+	registry *prometheus.Registry
+	handler  http.Handler
+
+	hits      *prometheus.CounterVec
+	misses    *prometheus.CounterVec
+	puts      prometheus.Counter
+	conflicts prometheus.Counter
+	errors    *prometheus.CounterVec
+
+	sent     prometheus.Counter
+	received prometheus.Counter
+
+	duration *prometheus.HistogramVec
+}
+
+// NewMetrics creates metrics of the requests.
+// If `store_interval` is positive and the store can enumerate its entries,
+// size of the store is also collected by enumerating the store at most once per `store_interval`.
+func NewMetrics(store Store, store_interval time.Duration) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		hits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "hits_total",
+			Help:      "Number of requests for the artifacts found in the store.",
+		}, []string{"method"}),
+		misses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "misses_total",
+			Help:      "Number of requests for the artifacts not found in the store.",
+		}, []string{"method"}),
+		puts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "puts_total",
+			Help:      "Number of artifacts uploaded.",
+		}),
+		conflicts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "conflicts_total",
+			Help:      "Number of uploads rejected since the artifact already exists.",
+		}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "errors_total",
+			Help:      "Number of requests failed by server errors or malformed paths.",
+		}, []string{"method"}),
+
+		sent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "sent_bytes_total",
+			Help:      "Number of bytes sent in response bodies.",
+		}),
+		received: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "received_bytes_total",
+			Help:      "Number of bytes received in request bodies.",
+		}),
+
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "request_duration_seconds",
+			Help:      "Duration of requests.",
+			Buckets:   []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 30, 60, 300},
+		}, []string{"method", "status"}),
+	}
+
+	m.registry.MustRegister(
+		m.hits,
+		m.misses,
+		m.puts,
+		m.conflicts,
+		m.errors,
+		m.sent,
+		m.received,
+		m.duration,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	if lister, ok := store.(Lister); ok && store_interval > 0 {
+		m.registry.MustRegister(newStoreCollector(lister, store_interval))
+	}
+
+	m.handler = promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	return m
+}
+
+// methodLabel returns the label for the method so that
+// arbitrary methods sent by clients do not create new series.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return method
+	default:
+		return "other"
+	}
+}
+
+// Observe records the result of the request.
+func (m *Metrics) Observe(method string, status int, err error, dt time.Duration, sent int64, received int64) {
+	method = methodLabel(method)
+
+	m.duration.WithLabelValues(method, strconv.Itoa(status)).Observe(dt.Seconds())
+	m.sent.Add(float64(sent))
+	m.received.Add(float64(received))
+
+	// `handleGet` returns the error of the store even if it is a miss.
+	is_miss := err == nil || errors.Is(err, ErrNotExist)
+	if is_miss && status == http.StatusNotFound && (method == http.MethodGet || method == http.MethodHead) {
+		m.misses.WithLabelValues(method).Inc()
+		return
+	}
+	if err != nil || status >= 500 {
+		m.errors.WithLabelValues(method).Inc()
+		return
+	}
+
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		switch status {
+		case http.StatusOK, http.StatusPartialContent, http.StatusNotModified:
+			m.hits.WithLabelValues(method).Inc()
+		}
+
+	case http.MethodPut:
+		switch status {
+		case http.StatusOK:
+			m.puts.Inc()
+		case http.StatusConflict:
+			m.conflicts.Inc()
+		}
+	}
+}
+
+// Handler serves the metrics in Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return m.handler
+}
+
+// storeCollector collects size of the store.
+// Enumeration of the store can be expensive so the result is reused for `ttl`.
+type storeCollector struct {
+	lister Lister
+	ttl    time.Duration
+
+	size_desc    *prometheus.Desc
+	entries_desc *prometheus.Desc
+
+	mutex        sync.Mutex
+	collected_at time.Time
+	size         int64
+	entries      int64
+}
+
+func newStoreCollector(lister Lister, ttl time.Duration) *storeCollector {
+	return &storeCollector{
+		lister: lister,
+		ttl:    ttl,
+
+		size_desc:    prometheus.NewDesc(metricsNamespace+"_store_size_bytes", "Total size of the artifacts in the store.", nil, nil),
+		entries_desc: prometheus.NewDesc(metricsNamespace+"_store_entries", "Number of the artifacts in the store.", nil, nil),
+	}
+}
+
+func (c *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.size_desc
+	ch <- c.entries_desc
+}
+
+func (c *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if time.Since(c.collected_at) > c.ttl {
+		size := int64(0)
+		entries := int64(0)
+		err := c.lister.List(context.Background(), func(entry Entry) error {
+			size += entry.Size
+			entries++
+			return nil
+		})
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(c.size_desc, err)
+			return
+		}
+
+		c.collected_at = time.Now()
+		c.size = size
+		c.entries = entries
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.size_desc, prometheus.GaugeValue, float64(c.size))
+	ch <- prometheus.MustNewConstMetric(c.entries_desc, prometheus.GaugeValue, float64(c.entries))
+}
@@ -0,0 +1,148 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	main "github.com/lesomnus/vcpkg-cache-http"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerMetrics(t *testing.T) {
+	t.Run("404 if metrics not enabled", WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+		require.HTTPStatusCode(handler.ServeHTTP, http.MethodGet, "/metrics", nil, http.StatusNotFound)
+	}))
+
+	t.Run("requests are counted", WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		handler.Metrics = main.NewMetrics(store, time.Minute)
+
+		data := randomData(t)
+		do := func(method string, body []byte) int {
+			req := httptest.NewRequest(method, DescriptionFoo.String(), bytes.NewReader(body))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			return w.Result().StatusCode
+		}
+
+		require.Equal(http.StatusNotFound, do(http.MethodGet, nil))
+		require.Equal(http.StatusNotFound, do(http.MethodHead, nil))
+		require.Equal(http.StatusOK, do(http.MethodPut, data))
+		require.Equal(http.StatusConflict, do(http.MethodPut, data))
+		require.Equal(http.StatusOK, do(http.MethodGet, nil))
+		require.Equal(http.StatusOK, do(http.MethodGet, nil))
+
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		res := w.Result()
+		require.Equal(http.StatusOK, res.StatusCode)
+
+		body, err := io.ReadAll(res.Body)
+		require.NoError(err)
+
+		metrics := string(body)
+		require.Contains(metrics, `vcpkg_cache_hits_total{method="GET"} 2`)
+		require.Contains(metrics, `vcpkg_cache_misses_total{method="GET"} 1`)
+		require.Contains(metrics, `vcpkg_cache_misses_total{method="HEAD"} 1`)
+		require.Contains(metrics, `vcpkg_cache_puts_total 1`)
+		require.Contains(metrics, `vcpkg_cache_conflicts_total 1`)
+		require.Contains(metrics, `vcpkg_cache_sent_bytes_total 256`)
+		require.Contains(metrics, `vcpkg_cache_received_bytes_total 128`)
+		require.Contains(metrics, `vcpkg_cache_request_duration_seconds_count{method="GET",status="200"} 2`)
+		require.Contains(metrics, `vcpkg_cache_store_entries 1`)
+		require.Contains(metrics, `vcpkg_cache_store_size_bytes 128`)
+	}))
+
+	t.Run("errors are counted", WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		handler.Store = &brokenStore{store}
+		handler.Metrics = main.NewMetrics(handler.Store, 0)
+
+		req := httptest.NewRequest(http.MethodGet, DescriptionFoo.String(), nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		// Malformed path is not a miss.
+		req = httptest.NewRequest(http.MethodGet, "/foo/bar", nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		require.HTTPBodyContains(handler.ServeHTTP, http.MethodGet, "/metrics", nil, `vcpkg_cache_errors_total{method="GET"} 2`)
+		require.HTTPBodyNotContains(handler.ServeHTTP, http.MethodGet, "/metrics", nil, `vcpkg_cache_misses_total`)
+	}))
+
+	t.Run("unknown methods share a label", WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		handler.Metrics = main.NewMetrics(store, 0)
+		for _, method := range []string{"FOO", "BAR", http.MethodPatch} {
+			req := httptest.NewRequest(method, DescriptionFoo.String(), nil)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}
+
+		require.HTTPBodyContains(handler.ServeHTTP, http.MethodGet, "/metrics", nil, `vcpkg_cache_request_duration_seconds_count{method="other",status="501"} 3`)
+		require.HTTPBodyNotContains(handler.ServeHTTP, http.MethodGet, "/metrics", nil, `method="FOO"`)
+	}))
+
+	t.Run("store is not enumerated unless interval is given", WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+		handler.Metrics = main.NewMetrics(store, 0)
+		require.HTTPBodyNotContains(t, handler.ServeHTTP, http.MethodGet, "/metrics", nil, `vcpkg_cache_store_entries`)
+	}))
+
+	t.Run("client must be able to read", WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		auth, err := main.NewAuthenticator(&main.AuthConfig{
+			ReadTokens:  map[string]string{"prometheus": "r-token"},
+			WriteTokens: map[string]string{"ci": "w-token"},
+		})
+		require.NoError(err)
+
+		handler.Auth = auth
+		handler.Metrics = main.NewMetrics(store, 0)
+
+		do := func(token string) int {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			return w.Result().StatusCode
+		}
+
+		require.Equal(http.StatusUnauthorized, do(""))
+		require.Equal(http.StatusForbidden, do("w-token"))
+		require.Equal(http.StatusOK, do("r-token"))
+	}))
+}
+
+type brokenStore struct {
+	main.Store
+}
+
+func (s *brokenStore) Get(ctx context.Context, desc main.Description) (io.ReadCloser, main.Info, error) {
+	return nil, main.Info{}, errors.New("broken")
+}
+
+func (s *brokenStore) Head(ctx context.Context, desc main.Description) (main.Info, error) {
+	return main.Info{}, errors.New("broken")
+}
+
+func (s *brokenStore) Put(ctx context.Context, desc main.Description, r io.Reader) error {
+	return errors.New("broken")
+}
+
+func (s *brokenStore) Delete(ctx context.Context, desc main.Description) error {
+	return errors.New("broken")
+}
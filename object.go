@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+// Helpers shared by the stores of object storages, e.g. `s3Store`, `gcsStore` and `azblobStore`,
+// where artifacts are stored as objects of `{prefix}/{name}/{version}/{hash}`.
+
+// objectListPrefix returns the prefix of the keys to list the objects under `prefix`.
+func objectListPrefix(prefix string) string {
+	if prefix == "" {
+		return ""
+	}
+
+	return prefix + "/"
+}
+
+// unresolveObjectKey returns the description of the object listed by `list_prefix`.
+// It returns false if the key is not in the layout.
+func unresolveObjectKey(list_prefix string, key string) (Description, bool) {
+	entries := strings.Split(strings.TrimPrefix(key, list_prefix), "/")
+	if len(entries) != 3 {
+		return Description{}, false
+	}
+
+	return Description{
+		Name:    entries[0],
+		Version: entries[1],
+		Hash:    entries[2],
+	}, true
+}
+
+// rangeReader reads an object from the offset and opens it again from the offset when it is seeked
+// so range requests are served by ranged reads of the object.
+// The object is opened by the first read so it is not opened twice
+// if it is seeked before the read, e.g. by `http.ServeContent`.
+type rangeReader struct {
+	// open opens the object to read from `off` to the end.
+	open func(off int64) (io.ReadCloser, error)
+
+	// Size of the object; it cannot be seeked from the end if it is negative.
+	size int64
+	off  int64
+
+	// Reader at `off`; nil if it is not opened yet or seeked.
+	r io.ReadCloser
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if r.size >= 0 && r.off >= r.size {
+		return 0, io.EOF
+	}
+	if r.r == nil {
+		rc, err := r.open(r.off)
+		if err != nil {
+			return 0, err
+		}
+
+		r.r = rc
+	}
+
+	n, err := r.r.Read(p)
+	r.off += int64(n)
+	return n, err
+}
+
+func (r *rangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		if r.size < 0 {
+			return 0, errors.New("size of the object is unknown")
+		}
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	if offset == r.off {
+		return offset, nil
+	}
+
+	if r.r != nil {
+		r.r.Close()
+		r.r = nil
+	}
+
+	r.off = offset
+	return offset, nil
+}
+
+func (r *rangeReader) Close() error {
+	if r.r == nil {
+		return nil
+	}
+
+	return r.r.Close()
+}
@@ -0,0 +1,594 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+const (
+	// Media type of the config of the manifests, which identifies the manifests as vcpkg artifacts.
+	ociConfigMediaType types.MediaType = "application/vnd.vcpkg.archive.config.v1+json"
+	ociLayerMediaType  types.MediaType = "application/zip"
+
+	ociAnnotationName     = "io.github.lesomnus.vcpkg-cache-http.name"
+	ociAnnotationVersion  = "io.github.lesomnus.vcpkg-cache-http.version"
+	ociAnnotationHash     = "io.github.lesomnus.vcpkg-cache-http.hash"
+	ociAnnotationUploader = "io.github.lesomnus.vcpkg-cache-http.uploader"
+	ociAnnotationCreated  = "org.opencontainers.image.created"
+
+	// Max length of tags by OCI distribution spec.
+	ociTagMaxLength = 128
+)
+
+// Content of the config blob shared by all manifests.
+var ociConfig = []byte("{}")
+
+type ociManifest struct {
+	SchemaVersion int64             `json:"schemaVersion"`
+	MediaType     types.MediaType   `json:"mediaType"`
+	Config        v1.Descriptor     `json:"config"`
+	Layers        []v1.Descriptor   `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+
+	digest v1.Hash
+}
+
+// isArtifact reports whether the manifest is of an artifact stored by `ociStore`.
+func (m *ociManifest) isArtifact() bool {
+	return m.Config.MediaType == ociConfigMediaType && len(m.Layers) == 1
+}
+
+func (m *ociManifest) description() Description {
+	return Description{
+		Name:    m.Annotations[ociAnnotationName],
+		Version: m.Annotations[ociAnnotationVersion],
+		Hash:    m.Annotations[ociAnnotationHash],
+	}
+}
+
+func (m *ociManifest) info() Info {
+	info := Info{Size: m.Layers[0].Size}
+	if t, err := time.Parse(time.RFC3339, m.Annotations[ociAnnotationCreated]); err == nil {
+		info.ModTime = t
+	}
+	if m.Layers[0].Digest.Algorithm == "sha256" {
+		info.Digest = m.Layers[0].Digest.Hex
+	}
+
+	return info
+}
+
+// ociManifestBytes implements `remote.Taggable` for a manifest being pushed.
+type ociManifestBytes []byte
+
+func (b ociManifestBytes) RawManifest() ([]byte, error) {
+	return b, nil
+}
+
+func (b ociManifestBytes) MediaType() (types.MediaType, error) {
+	return types.OCIManifestSchema1, nil
+}
+
+// ociTag returns the tag of the artifact in form of `{name}_{version}_{hash}`.
+// Characters not allowed in tags are replaced by "-" and the name and the version are truncated
+// to fit in the length limit, so the description is recorded in the annotations of the manifest as well.
+func ociTag(desc Description) string {
+	sanitize := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			switch {
+			case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '_', r == '.', r == '-':
+				return r
+			default:
+				return '-'
+			}
+		}, s)
+	}
+
+	hash := sanitize(desc.Hash)
+	tag := sanitize(desc.Name) + "_" + sanitize(desc.Version)
+	if n := ociTagMaxLength - len(hash) - 1; len(tag) > n {
+		if n < 0 {
+			n = 0
+		}
+		tag = tag[:n]
+	}
+
+	tag += "_" + hash
+	if len(tag) > ociTagMaxLength {
+		tag = tag[:ociTagMaxLength]
+	}
+	if tag[0] == '.' || tag[0] == '-' {
+		tag = "_" + tag[1:]
+	}
+
+	return tag
+}
+
+// ociStore stores artifacts in a repository of an OCI distribution registry.
+// Each artifact is a manifest with a single layer of the archive, tagged by `ociTag`.
+type ociStore struct {
+	repo name.Repository
+
+	auth     authn.Authenticator
+	insecure bool
+
+	// Directory where uploads are spooled; the temporary directory of the system if it is empty.
+	spool_dir string
+
+	puller *remote.Puller
+	pusher *remote.Pusher
+
+	// Manifests read by `List` keyed by their digests.
+	// Manifests are immutable so only the digests of the tags are resolved by HEAD on the next listing.
+	manifests       map[v1.Hash]*ociManifest
+	manifests_mutex sync.Mutex
+}
+
+type ociOption func(s *ociStore)
+
+// WithOciAuth sets the credentials for the registry.
+// Credentials are found from Docker config file, `~/.docker/config.json`, if it is not given.
+func WithOciAuth(auth authn.Authenticator) ociOption {
+	return func(s *ociStore) {
+		s.auth = auth
+	}
+}
+
+// WithOciInsecure makes the store connect to the registry by plain HTTP.
+func WithOciInsecure() ociOption {
+	return func(s *ociStore) {
+		s.insecure = true
+	}
+}
+
+// WithOciSpoolDir sets the directory where uploads are spooled to find their digests.
+func WithOciSpoolDir(p string) ociOption {
+	return func(s *ociStore) {
+		s.spool_dir = p
+	}
+}
+
+// NewOciStore creates a store for the repository such as "ghcr.io/lesomnus/vcpkg-cache".
+func NewOciStore(repo string, opts ...ociOption) (*ociStore, error) {
+	s := &ociStore{manifests: map[v1.Hash]*ociManifest{}}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	name_opts := []name.Option{}
+	if s.insecure {
+		name_opts = append(name_opts, name.Insecure)
+	}
+
+	r, err := name.NewRepository(repo, name_opts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository: %w", err)
+	}
+	s.repo = r
+
+	remote_opts := []remote.Option{remote.WithAuthFromKeychain(authn.DefaultKeychain)}
+	if s.auth != nil {
+		remote_opts = []remote.Option{remote.WithAuth(s.auth)}
+	}
+
+	s.puller, err = remote.NewPuller(remote_opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create puller: %w", err)
+	}
+	s.pusher, err = remote.NewPusher(remote_opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create pusher: %w", err)
+	}
+
+	return s, nil
+}
+
+// newOciStoreFromConfig creates a store from options such as:
+//
+//	oci:ghcr.io/lesomnus/vcpkg-cache,username=ci,password_file=/run/secrets/registry
+//	oci:ghcr.io/lesomnus/vcpkg-cache,username=ci,password_env=REGISTRY_PASSWORD
+//	oci:registry:5000/vcpkg-cache,insecure,spool_dir=/var/spool/vcpkg-cache
+func newOciStoreFromConfig(conf *StoreConfig) (*ociStore, error) {
+	if conf.Path == "" {
+		return nil, errors.New("repository must be specified")
+	}
+
+	opts := []ociOption{}
+	if p, ok := conf.Opts["spool_dir"]; ok {
+		opts = append(opts, WithOciSpoolDir(p))
+	}
+	if insecure, err := conf.Bool("insecure"); err != nil {
+		return nil, err
+	} else if insecure {
+		opts = append(opts, WithOciInsecure())
+	}
+
+	password, err := ociPasswordFromConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	username, has_username := conf.Opts["username"]
+	if has_username {
+		opts = append(opts, WithOciAuth(authn.FromConfig(authn.AuthConfig{
+			Username: username,
+			Password: password,
+		})))
+	}
+
+	return NewOciStore(conf.Path, opts...)
+}
+
+// ociPasswordFromConfig reads the password from the file given by "password_file" option
+// or from the environment variable given by "password_env" option
+// so it does not have to appear in the command line by "password" option.
+func ociPasswordFromConfig(conf *StoreConfig) (string, error) {
+	password, has_password := conf.Opts["password"]
+	p, has_file := conf.Opts["password_file"]
+	env, has_env := conf.Opts["password_env"]
+
+	n := 0
+	for _, has := range []bool{has_password, has_file, has_env} {
+		if has {
+			n++
+		}
+	}
+	if n > 1 {
+		return "", errors.New("only one of password, password_file and password_env can be given")
+	}
+
+	switch {
+	case has_file:
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return "", fmt.Errorf("read password file: %w", err)
+		}
+
+		return strings.TrimRight(string(data), "\r\n"), nil
+
+	case has_env:
+		password, ok := os.LookupEnv(env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s for password is not set", env)
+		}
+
+		return password, nil
+
+	default:
+		return password, nil
+	}
+}
+
+func (s *ociStore) Resolve(desc Description) name.Tag {
+	return s.repo.Tag(ociTag(desc))
+}
+
+func ociError(err error) error {
+	var err_transport *transport.Error
+	if errors.As(err, &err_transport) && err_transport.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrNotExist, err.Error())
+	}
+
+	return err
+}
+
+// ociUnsupported reports whether the registry does not support the operation.
+func ociUnsupported(err error) bool {
+	var err_transport *transport.Error
+	if !errors.As(err, &err_transport) {
+		return false
+	}
+	if err_transport.StatusCode == http.StatusMethodNotAllowed {
+		return true
+	}
+	for _, d := range err_transport.Errors {
+		if d.Code == transport.UnsupportedErrorCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fetch fetches the manifest of the given reference.
+func (s *ociStore) fetch(ctx context.Context, ref name.Reference) (*ociManifest, error) {
+	d, err := s.puller.Get(ctx, ref)
+	if err != nil {
+		return nil, ociError(err)
+	}
+
+	m := &ociManifest{digest: d.Digest}
+	if err := json.Unmarshal(d.Manifest, m); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+
+	return m, nil
+}
+
+// manifest fetches the manifest of the artifact.
+func (s *ociStore) manifest(ctx context.Context, desc Description) (*ociManifest, error) {
+	m, err := s.fetch(ctx, s.Resolve(desc))
+	if err != nil {
+		return nil, err
+	}
+	if !m.isArtifact() || m.description() != desc {
+		// Tags of artifacts can be the same if they are truncated.
+		return nil, fmt.Errorf("%w: tag is used by another artifact", ErrNotExist)
+	}
+
+	return m, nil
+}
+
+func (s *ociStore) Get(ctx context.Context, desc Description) (io.ReadCloser, Info, error) {
+	m, err := s.manifest(ctx, desc)
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	l, err := s.puller.Layer(ctx, s.repo.Digest(m.Layers[0].Digest.String()))
+	if err != nil {
+		return nil, Info{}, ociError(err)
+	}
+
+	// Content is verified against the digest as it is read.
+	r, err := l.Compressed()
+	if err != nil {
+		return nil, Info{}, ociError(err)
+	}
+
+	return r, m.info(), nil
+}
+
+func (s *ociStore) Head(ctx context.Context, desc Description) (Info, error) {
+	m, err := s.manifest(ctx, desc)
+	if err != nil {
+		return Info{}, err
+	}
+
+	return m.info(), nil
+}
+
+// Put writes the artifact to a temporary file in the spool directory to find its digest,
+// uploads it as a blob and then tags a manifest referring the blob.
+// Registries do not support conditional uploads of manifests so concurrent uploads of
+// the same artifact are all accepted.
+func (s *ociStore) Put(ctx context.Context, desc Description, r io.Reader) error {
+	tag := s.Resolve(desc)
+	if m, err := s.fetch(ctx, tag); err == nil {
+		if !m.isArtifact() || m.description() != desc {
+			return fmt.Errorf("tag %s is used by another artifact", tag.TagStr())
+		}
+		return ErrExist
+	} else if !errors.Is(err, ErrNotExist) {
+		return fmt.Errorf("get manifest: %w", err)
+	}
+
+	if s.spool_dir != "" {
+		if err := os.MkdirAll(s.spool_dir, 0744); err != nil {
+			return fmt.Errorf("create spool directory: %w", err)
+		}
+	}
+
+	f, err := os.CreateTemp(s.spool_dir, "vcpkg-cache-http-")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		return err
+	}
+
+	blob, err := partial.CompressedToLayer(&ociFileLayer{
+		f:      f,
+		size:   size,
+		digest: v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(h.Sum(nil))},
+	})
+	if err != nil {
+		return err
+	}
+
+	config := static.NewLayer(ociConfig, ociConfigMediaType)
+	for _, l := range []v1.Layer{config, blob} {
+		if err := s.pusher.Upload(ctx, s.repo, l); err != nil {
+			return fmt.Errorf("upload blob: %w", err)
+		}
+	}
+
+	annotations := map[string]string{
+		ociAnnotationName:    desc.Name,
+		ociAnnotationVersion: desc.Version,
+		ociAnnotationHash:    desc.Hash,
+		ociAnnotationCreated: time.Now().UTC().Format(time.RFC3339),
+	}
+	if client, ok := ClientFromContext(ctx); ok {
+		annotations[ociAnnotationUploader] = client.Name
+	}
+
+	config_desc, err := partial.Descriptor(config)
+	if err != nil {
+		return err
+	}
+	blob_desc, err := partial.Descriptor(blob)
+	if err != nil {
+		return err
+	}
+
+	m, err := json.Marshal(ociManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+		Config:        *config_desc,
+		Layers:        []v1.Descriptor{*blob_desc},
+		Annotations:   annotations,
+	})
+	if err != nil {
+		return fmt.Errorf("encode manifest: %w", err)
+	}
+
+	if err := s.pusher.Push(ctx, tag, ociManifestBytes(m)); err != nil {
+		return fmt.Errorf("push manifest: %w", err)
+	}
+
+	return nil
+}
+
+// Delete deletes the tag and the manifest of the artifact.
+// The blob is removed by garbage collection of the registry.
+func (s *ociStore) Delete(ctx context.Context, desc Description) error {
+	m, err := s.manifest(ctx, desc)
+	if err != nil {
+		return err
+	}
+
+	// Deletion of tags is optional in the spec; registries not supporting it
+	// remove the tags along with the manifest deleted by its digest.
+	err = s.pusher.Delete(ctx, s.Resolve(desc))
+	if err != nil && !ociUnsupported(err) && !errors.Is(ociError(err), ErrNotExist) {
+		return fmt.Errorf("delete tag: %w", err)
+	}
+
+	err = s.pusher.Delete(ctx, s.repo.Digest(m.digest.String()))
+	if err != nil && !errors.Is(ociError(err), ErrNotExist) {
+		return fmt.Errorf("delete manifest: %w", err)
+	}
+
+	return nil
+}
+
+// listed returns the manifest of the tag for `List`.
+// The manifest is fetched only if it is not read by the previous listings.
+func (s *ociStore) listed(ctx context.Context, tag name.Tag) (*ociManifest, error) {
+	d, err := s.puller.Head(ctx, tag)
+	if err != nil {
+		return nil, ociError(err)
+	}
+
+	s.manifests_mutex.Lock()
+	m, ok := s.manifests[d.Digest]
+	s.manifests_mutex.Unlock()
+	if ok {
+		return m, nil
+	}
+
+	m, err = s.fetch(ctx, s.repo.Digest(d.Digest.String()))
+	if err != nil {
+		return nil, err
+	}
+
+	s.manifests_mutex.Lock()
+	s.manifests[d.Digest] = m
+	s.manifests_mutex.Unlock()
+
+	return m, nil
+}
+
+// List lists the artifacts by tags of the repository.
+// The manifest of each tag is read to find the description of the artifact,
+// which is fetched once and then found by the digest of the tag.
+func (s *ociStore) List(ctx context.Context, fn func(entry Entry) error) error {
+	lister, err := s.puller.Lister(ctx, s.repo)
+	if err != nil {
+		if errors.Is(ociError(err), ErrNotExist) {
+			// Repository is created on the first upload.
+			return nil
+		}
+		return fmt.Errorf("list tags: %w", err)
+	}
+
+	seen := map[v1.Hash]*ociManifest{}
+	for lister.HasNext() {
+		page, err := lister.Next(ctx)
+		if err != nil {
+			return fmt.Errorf("list tags: %w", err)
+		}
+
+		for _, tag := range page.Tags {
+			m, err := s.listed(ctx, s.repo.Tag(tag))
+			if errors.Is(err, ErrNotExist) {
+				// Deleted while listing.
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("get manifest of %s: %w", tag, err)
+			}
+
+			seen[m.digest] = m
+
+			desc := m.description()
+			if !m.isArtifact() || ociTag(desc) != tag {
+				// Tagged by others.
+				continue
+			}
+
+			info := m.info()
+			err = fn(Entry{
+				Description: desc,
+				Size:        info.Size,
+				ModTime:     info.ModTime,
+				Digest:      info.Digest,
+				Uploader:    m.Annotations[ociAnnotationUploader],
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Forget the manifests no longer tagged.
+	s.manifests_mutex.Lock()
+	s.manifests = seen
+	s.manifests_mutex.Unlock()
+
+	return nil
+}
+
+func (s *ociStore) Close() error {
+	return nil
+}
+
+// ociFileLayer is a blob to be uploaded from a file.
+type ociFileLayer struct {
+	f      *os.File
+	size   int64
+	digest v1.Hash
+}
+
+func (l *ociFileLayer) Digest() (v1.Hash, error) {
+	return l.digest, nil
+}
+
+// Compressed returns the content of the file; it is read again on every call
+// so failed uploads can be retried.
+func (l *ociFileLayer) Compressed() (io.ReadCloser, error) {
+	return io.NopCloser(io.NewSectionReader(l.f, 0, l.size)), nil
+}
+
+func (l *ociFileLayer) Size() (int64, error) {
+	return l.size, nil
+}
+
+func (l *ociFileLayer) MediaType() (types.MediaType, error) {
+	return ociLayerMediaType, nil
+}
@@ -0,0 +1,289 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	main "github.com/lesomnus/vcpkg-cache-http"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// NewTestRegistry starts an in-memory OCI registry and returns its host.
+func NewTestRegistry(t *testing.T) string {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+type OciStoreSetup struct{}
+
+func (s *OciStoreSetup) New(t *testing.T) (main.Store, error) {
+	return main.NewOciStore(NewTestRegistry(t) + "/vcpkg")
+}
+
+func TestOciStoreSuite(t *testing.T) {
+	suite.Run(t, &StoreTestSuite{Store: &OciStoreSetup{}})
+}
+
+func TestOciStore(t *testing.T) {
+	t.Run("artifact is tagged by its description", func(t *testing.T) {
+		require := require.New(t)
+
+		host := NewTestRegistry(t)
+		store, err := main.NewOciStore(host + "/vcpkg")
+		require.NoError(err)
+
+		data := randomData(t)
+		err = store.Put(context.Background(), DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		repo, err := name.NewRepository(host + "/vcpkg")
+		require.NoError(err)
+
+		tags, err := remote.List(repo)
+		require.NoError(err)
+		require.Equal([]string{"foo_bar_baz"}, tags)
+
+		d, err := remote.Get(repo.Tag("foo_bar_baz"))
+		require.NoError(err)
+
+		m := v1.Manifest{}
+		err = json.Unmarshal(d.Manifest, &m)
+		require.NoError(err)
+		require.Equal("application/vnd.vcpkg.archive.config.v1+json", string(m.Config.MediaType))
+		require.Len(m.Layers, 1)
+		require.Equal("application/zip", string(m.Layers[0].MediaType))
+
+		digest := sha256.Sum256(data)
+		require.Equal(hex.EncodeToString(digest[:]), m.Layers[0].Digest.Hex)
+	})
+
+	t.Run("artifacts with truncated tags do not collide", func(t *testing.T) {
+		require := require.New(t)
+
+		store, err := main.NewOciStore(NewTestRegistry(t) + "/vcpkg")
+		require.NoError(err)
+
+		// Both are tagged by "foo_" followed by "a"s and the hash.
+		a := main.Description{Name: "foo", Version: strings.Repeat("a", 100) + "+1", Hash: strings.Repeat("0", 64)}
+		b := main.Description{Name: "foo", Version: strings.Repeat("a", 100) + "+2", Hash: strings.Repeat("0", 64)}
+
+		ctx := context.Background()
+		err = store.Put(ctx, a, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		err = store.Put(ctx, b, bytes.NewReader(randomData(t)))
+		require.ErrorContains(err, "another artifact")
+
+		_, err = store.Head(ctx, a)
+		require.NoError(err)
+		_, err = store.Head(ctx, b)
+		require.ErrorIs(err, main.ErrNotExist)
+	})
+
+	t.Run("images in the repository are not listed", func(t *testing.T) {
+		require := require.New(t)
+
+		host := NewTestRegistry(t)
+		store, err := main.NewOciStore(host + "/vcpkg")
+		require.NoError(err)
+
+		img, err := random.Image(64, 1)
+		require.NoError(err)
+		tag, err := name.NewTag(host + "/vcpkg:latest")
+		require.NoError(err)
+		err = remote.Write(tag, img)
+		require.NoError(err)
+
+		ctx := context.Background()
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		entries := []main.Entry{}
+		err = store.List(ctx, func(entry main.Entry) error {
+			entries = append(entries, entry)
+			return nil
+		})
+		require.NoError(err)
+		require.Len(entries, 1)
+		require.Equal(DescriptionFoo, entries[0].Description)
+	})
+
+	t.Run("repository not created yet is empty", func(t *testing.T) {
+		require := require.New(t)
+
+		store, err := main.NewOciStore(NewTestRegistry(t) + "/vcpkg")
+		require.NoError(err)
+
+		err = store.List(context.Background(), func(entry main.Entry) error {
+			require.Fail("no entry is expected")
+			return nil
+		})
+		require.NoError(err)
+	})
+
+	t.Run("entries are listed with metadata", func(t *testing.T) {
+		require := require.New(t)
+
+		store, err := main.NewOciStore(NewTestRegistry(t) + "/vcpkg")
+		require.NoError(err)
+
+		auth, err := main.NewAuthenticator(&main.AuthConfig{
+			WriteTokens: map[string]string{"ci": "s3cret"},
+		})
+		require.NoError(err)
+
+		handler := &main.Handler{
+			Store: store,
+			Log:   zerolog.New(io.Discard),
+			Auth:  auth,
+
+			IsWritable: true,
+		}
+
+		data := randomData(t)
+		req := httptest.NewRequest(http.MethodPut, DescriptionFoo.String(), bytes.NewReader(data))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(http.StatusOK, w.Result().StatusCode)
+
+		ctx := context.Background()
+		info, err := store.Head(ctx, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(int64(len(data)), info.Size)
+		require.False(info.ModTime.IsZero())
+
+		entries := []main.Entry{}
+		err = store.List(ctx, func(entry main.Entry) error {
+			entries = append(entries, entry)
+			return nil
+		})
+		require.NoError(err)
+		require.Len(entries, 1)
+		require.Equal(DescriptionFoo, entries[0].Description)
+		require.Equal(int64(len(data)), entries[0].Size)
+		require.Equal(info.Digest, entries[0].Digest)
+		require.Equal("ci", entries[0].Uploader)
+	})
+
+	t.Run("manifests are fetched once across listings", func(t *testing.T) {
+		require := require.New(t)
+
+		reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+		manifest_gets := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/manifests/") {
+				manifest_gets++
+			}
+			reg.ServeHTTP(w, r)
+		}))
+		defer server.Close()
+
+		store, err := main.NewOciStore(strings.TrimPrefix(server.URL, "http://") + "/vcpkg")
+		require.NoError(err)
+
+		ctx := context.Background()
+		for _, desc := range []main.Description{DescriptionFoo, {Name: "foo", Version: "bar", Hash: "qux"}} {
+			err = store.Put(ctx, desc, bytes.NewReader(randomData(t)))
+			require.NoError(err)
+		}
+
+		list := func() int {
+			n := 0
+			err := store.List(ctx, func(entry main.Entry) error {
+				n++
+				return nil
+			})
+			require.NoError(err)
+			return n
+		}
+
+		manifest_gets = 0
+		require.Equal(2, list())
+		require.Equal(2, manifest_gets)
+
+		manifest_gets = 0
+		require.Equal(2, list())
+		require.Equal(0, manifest_gets)
+	})
+
+	t.Run("upload is spooled to the spool directory", func(t *testing.T) {
+		require := require.New(t)
+
+		spool := filepath.Join(t.TempDir(), "spool")
+		spooled := 0
+
+		reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.Contains(r.URL.Path, "/blobs/uploads/") {
+				if entries, err := os.ReadDir(spool); err == nil {
+					spooled = len(entries)
+				}
+			}
+			reg.ServeHTTP(w, r)
+		}))
+		defer server.Close()
+
+		store, err := main.NewOciStore(strings.TrimPrefix(server.URL, "http://")+"/vcpkg", main.WithOciSpoolDir(spool))
+		require.NoError(err)
+
+		err = store.Put(context.Background(), DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+		require.Equal(1, spooled)
+
+		// Spooled file is removed.
+		entries, err := os.ReadDir(spool)
+		require.NoError(err)
+		require.Empty(entries)
+	})
+
+	t.Run("failure of tag deletion is reported unless it is not supported", func(t *testing.T) {
+		require := require.New(t)
+
+		reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+		status := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodDelete && strings.HasSuffix(r.URL.Path, "/manifests/foo_bar_baz") {
+				w.WriteHeader(status)
+				return
+			}
+			reg.ServeHTTP(w, r)
+		}))
+		defer server.Close()
+
+		store, err := main.NewOciStore(strings.TrimPrefix(server.URL, "http://") + "/vcpkg")
+		require.NoError(err)
+
+		ctx := context.Background()
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		status = http.StatusInternalServerError
+		err = store.Delete(ctx, DescriptionFoo)
+		require.ErrorContains(err, "delete tag")
+
+		status = http.StatusMethodNotAllowed
+		err = store.Delete(ctx, DescriptionFoo)
+		require.NoError(err)
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/rs/zerolog"
+)
+
+// proxyStore serves artifacts from the local store and
+// fetches them from the upstream on a miss, persisting them to the local store.
+type proxyStore struct {
+	local    Store
+	upstream string
+	client   *http.Client
+}
+
+type proxyOption func(s *proxyStore)
+
+func WithHttpClient(c *http.Client) proxyOption {
+	return func(s *proxyStore) {
+		s.client = c
+	}
+}
+
+// listingProxyStore is a proxy store whose local store can enumerate its entries.
+type listingProxyStore struct {
+	*proxyStore
+}
+
+// NewProxyStore creates a store backed by `local` that reads through `upstream` on a miss.
+// `upstream` is an URL of vcpkg HTTP binary source that may contain `{name}`, `{version}` and `{sha}` placeholders.
+// If there is no placeholder, it is considered as a base URL of another vcpkg-cache-http server.
+// The returned store implements `Lister` only if `local` does.
+func NewProxyStore(local Store, upstream string, opts ...proxyOption) (Store, error) {
+	s := &proxyStore{local: local, upstream: upstream}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.upstream == "" {
+		return nil, errors.New("upstream must be specified")
+	}
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+
+	s.upstream = expandUrlTemplate(s.upstream)
+
+	if _, ok := s.local.(Lister); ok {
+		return &listingProxyStore{s}, nil
+	}
+
+	return s, nil
+}
+
+func (s *proxyStore) Resolve(desc Description) string {
+	return resolveUrlTemplate(s.upstream, desc)
+}
+
+func (s *proxyStore) fetch(ctx context.Context, method string, desc Description) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.Resolve(desc), nil)
+	if err != nil {
+		return nil, fmt.Errorf("create upstream request: %w", err)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request upstream: %w", err)
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return res, nil
+
+	case http.StatusNotFound:
+		res.Body.Close()
+		return nil, fmt.Errorf("%w: upstream responds %s", ErrNotExist, res.Status)
+
+	default:
+		res.Body.Close()
+		return nil, fmt.Errorf("upstream responds %s", res.Status)
+	}
+}
+
+// upstreamInfo returns metadata of the artifact from the upstream response.
+func upstreamInfo(res *http.Response) Info {
+	info := Info{Size: res.ContentLength}
+	if t, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = t
+	}
+
+	return info
+}
+
+func (s *proxyStore) Get(ctx context.Context, desc Description) (io.ReadCloser, Info, error) {
+	r, info, err := s.local.Get(ctx, desc)
+	if err == nil {
+		return r, info, nil
+	}
+	if errors.Is(err, ErrCorrupted) {
+		// Corrupted artifact is quarantined by the local store so it can be fetched again.
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("local artifact is corrupted")
+	} else if !errors.Is(err, ErrNotExist) {
+		return nil, Info{}, err
+	}
+
+	res, err := s.fetch(ctx, http.MethodGet, desc)
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	return newPersistingReader(ctx, res.Body, desc, s.local), upstreamInfo(res), nil
+}
+
+func (s *proxyStore) Head(ctx context.Context, desc Description) (Info, error) {
+	info, err := s.local.Head(ctx, desc)
+	if !errors.Is(err, ErrNotExist) {
+		return info, err
+	}
+
+	res, err := s.fetch(ctx, http.MethodHead, desc)
+	if err != nil {
+		return Info{}, err
+	}
+
+	res.Body.Close()
+	if res.ContentLength < 0 {
+		return Info{}, errors.New("upstream does not respond content length")
+	}
+
+	return upstreamInfo(res), nil
+}
+
+func (s *proxyStore) Put(ctx context.Context, desc Description, r io.Reader) error {
+	return s.local.Put(ctx, desc, r)
+}
+
+// Delete deletes the entry from the local store.
+// Note that the entry will be fetched from the upstream again.
+func (s *proxyStore) Delete(ctx context.Context, desc Description) error {
+	return s.local.Delete(ctx, desc)
+}
+
+// List lists the entries in the local store.
+func (s *listingProxyStore) List(ctx context.Context, fn func(entry Entry) error) error {
+	return s.local.(Lister).List(ctx, fn)
+}
+
+func (s *proxyStore) Close() error {
+	return s.local.Close()
+}
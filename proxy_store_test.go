@@ -0,0 +1,200 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	main "github.com/lesomnus/vcpkg-cache-http"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+func NewTestUpstream(t *testing.T) (main.Store, string) {
+	require := require.New(t)
+
+	store, err := NewTestFsStore(t)
+	require.NoError(err)
+
+	server := httptest.NewServer(&main.Handler{
+		Store: store,
+		Log:   zerolog.New(io.Discard),
+
+		IsReadable: true,
+		IsWritable: true,
+	})
+	t.Cleanup(server.Close)
+
+	return store, server.URL
+}
+
+type ProxyStoreSetup struct{}
+
+func (s *ProxyStoreSetup) New(t *testing.T) (main.Store, error) {
+	local, err := NewTestFsStore(t)
+	if err != nil {
+		return nil, err
+	}
+
+	_, url := NewTestUpstream(t)
+	return main.NewProxyStore(local, url)
+}
+
+func TestProxyStoreSuite(t *testing.T) {
+	suite.Run(t, &StoreTestSuite{Store: &ProxyStoreSetup{}})
+}
+
+func TestNewProxyStore(t *testing.T) {
+	t.Run("upstream must be specified", func(t *testing.T) {
+		require := require.New(t)
+
+		local, err := NewTestFsStore(t)
+		require.NoError(err)
+
+		_, err = main.NewProxyStore(local, "")
+		require.ErrorContains(err, "upstream")
+	})
+
+	t.Run("store can enumerate entries only if local store can", func(t *testing.T) {
+		require := require.New(t)
+
+		local, err := NewTestFsStore(t)
+		require.NoError(err)
+
+		_, url := NewTestUpstream(t)
+		store, err := main.NewProxyStore(local, url)
+		require.NoError(err)
+		require.Implements((*main.Lister)(nil), store)
+
+		store, err = main.NewProxyStore(struct{ main.Store }{local}, url)
+		require.NoError(err)
+		_, ok := store.(main.Lister)
+		require.False(ok)
+
+		_, err = main.NewRetention(store, &main.RetentionConfig{MaxAge: main.Duration(time.Hour)})
+		require.Error(err)
+	})
+}
+
+func TestProxyStore(t *testing.T) {
+	WithProxyStore := func(f func(t *testing.T, upstream main.Store, local main.Store, store main.Store)) func(*testing.T) {
+		return func(t *testing.T) {
+			require := require.New(t)
+
+			upstream, url := NewTestUpstream(t)
+			local, err := NewTestFsStore(t)
+			require.NoError(err)
+
+			store, err := main.NewProxyStore(local, url)
+			require.NoError(err)
+
+			f(t, upstream, local, store)
+		}
+	}
+
+	t.Run("artifact is fetched from upstream and persisted on miss", WithProxyStore(func(t *testing.T, upstream main.Store, local main.Store, store main.Store) {
+		require := require.New(t)
+
+		ctx := context.Background()
+		data := randomData(t)
+		err := upstream.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		info, err := store.Head(ctx, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(int64(len(data)), info.Size)
+
+		_, err = local.Head(ctx, DescriptionFoo)
+		require.ErrorIs(err, main.ErrNotExist)
+
+		received, err := readAll(ctx, store, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(data, received)
+
+		received, err = readAll(ctx, local, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(data, received)
+	}))
+
+	t.Run("upload is stored to local", WithProxyStore(func(t *testing.T, upstream main.Store, local main.Store, store main.Store) {
+		require := require.New(t)
+
+		ctx := context.Background()
+		err := store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		_, err = local.Head(ctx, DescriptionFoo)
+		require.NoError(err)
+
+		_, err = upstream.Head(ctx, DescriptionFoo)
+		require.ErrorIs(err, main.ErrNotExist)
+	}))
+
+	t.Run("upstream URL with placeholders", func(t *testing.T) {
+		require := require.New(t)
+
+		var requested string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested = r.URL.Path
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		local, err := NewTestFsStore(t)
+		require.NoError(err)
+
+		store, err := main.NewProxyStore(local, server.URL+"/cache/{sha}-{name}-{version}.zip")
+		require.NoError(err)
+
+		_, err = store.Head(context.Background(), DescriptionFoo)
+		require.ErrorIs(err, main.ErrNotExist)
+		require.Equal("/cache/baz-foo-bar.zip", requested)
+	})
+
+	t.Run("fields are escaped in upstream URL", func(t *testing.T) {
+		require := require.New(t)
+
+		var requested string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested = r.URL.EscapedPath()
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		local, err := NewTestFsStore(t)
+		require.NoError(err)
+
+		store, err := main.NewProxyStore(local, server.URL+"/cache/{sha}-{name}-{version}.zip")
+		require.NoError(err)
+
+		// `#` would start the fragment if it is not escaped.
+		desc := main.Description{Name: "foo", Version: "1.0#2", Hash: "baz"}
+		_, err = store.Head(context.Background(), desc)
+		require.ErrorIs(err, main.ErrNotExist)
+		require.Equal("/cache/baz-foo-1.0%232.zip", requested)
+	})
+
+	t.Run("error if upstream fails", func(t *testing.T) {
+		require := require.New(t)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		local, err := NewTestFsStore(t)
+		require.NoError(err)
+
+		store, err := main.NewProxyStore(local, server.URL)
+		require.NoError(err)
+
+		_, _, err = store.Get(context.Background(), DescriptionFoo)
+		require.ErrorContains(err, "500")
+		require.NotErrorIs(err, main.ErrNotExist)
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type RetentionConfig struct {
+	// Entries older than this are expired.
+	// 0 means entries are kept forever.
+	MaxAge Duration `json:"max_age"`
+
+	// Max age for each package name which overrides `MaxAge`.
+	Overrides map[string]Duration `json:"overrides,omitempty"`
+
+	// Interval between sweeps; an hour by default.
+	Interval Duration `json:"interval,omitempty"`
+}
+
+type Retention struct {
+	Store Store
+	Log   zerolog.Logger
+
+	MaxAge    time.Duration
+	Overrides map[string]time.Duration
+}
+
+func NewRetention(store Store, conf *RetentionConfig) (*Retention, error) {
+	if _, ok := store.(Lister); !ok {
+		return nil, errors.New("store cannot enumerate its entries")
+	}
+
+	r := &Retention{
+		Store:     store,
+		Log:       zerolog.Nop(),
+		MaxAge:    time.Duration(conf.MaxAge),
+		Overrides: map[string]time.Duration{},
+	}
+	for name, age := range conf.Overrides {
+		r.Overrides[name] = time.Duration(age)
+	}
+
+	return r, nil
+}
+
+// MaxAgeOf returns the max age of the package of given name.
+func (r *Retention) MaxAgeOf(name string) time.Duration {
+	if age, ok := r.Overrides[name]; ok {
+		return age
+	}
+
+	return r.MaxAge
+}
+
+// Sweep deletes the entries expired at `now` and returns the number of deleted entries.
+func (r *Retention) Sweep(ctx context.Context, now time.Time) (int, error) {
+	lister, ok := r.Store.(Lister)
+	if !ok {
+		return 0, errors.New("store cannot enumerate its entries")
+	}
+
+	expired := []Entry{}
+	err := lister.List(ctx, func(entry Entry) error {
+		// Expiring the entry by `MaxAge` could remove a package that is overridden to be kept longer.
+		if entry.Name == "" && len(r.Overrides) > 0 {
+			return fmt.Errorf("overrides cannot be applied to %s: %w", entry.Hash, ErrUnnamed)
+		}
+
+		age := r.MaxAgeOf(entry.Name)
+		if age > 0 && now.Sub(entry.ModTime) > age {
+			expired = append(expired, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("list entries: %w", err)
+	}
+
+	n := 0
+	for _, entry := range expired {
+		if err := r.Store.Delete(ctx, entry.Description); err != nil {
+			if errors.Is(err, ErrNotExist) {
+				continue
+			}
+
+			return n, fmt.Errorf("delete %s: %w", entry.String(), err)
+		}
+
+		n++
+		r.Log.Info().
+			Str("name", entry.Name).
+			Str("version", entry.Version).
+			Str("hash", entry.Hash).
+			Time("mod_time", entry.ModTime).
+			Msg("expired")
+	}
+
+	return n, nil
+}
+
+// Run sweeps the store every `interval` until the context is done.
+func (r *Retention) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := r.Sweep(ctx, time.Now())
+		if err != nil {
+			r.Log.Error().Err(err).Msg("failed to sweep expired entries")
+		} else {
+			r.Log.Info().Int("deleted", n).Msg("sweep expired entries")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	main "github.com/lesomnus/vcpkg-cache-http"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetentionSweep(t *testing.T) {
+	require := require.New(t)
+
+	store, err := main.NewFsStore(t.TempDir())
+	require.NoError(err)
+
+	now := time.Now()
+	entries := []struct {
+		desc    main.Description
+		age     time.Duration
+		expired bool
+	}{
+		{desc: main.Description{Name: "zlib", Version: "1", Hash: "a"}, age: 1 * time.Hour, expired: false},
+		{desc: main.Description{Name: "zlib", Version: "1", Hash: "b"}, age: 15 * 24 * time.Hour, expired: true},
+		{desc: main.Description{Name: "boost", Version: "1", Hash: "c"}, age: 15 * 24 * time.Hour, expired: false},
+		{desc: main.Description{Name: "boost", Version: "1", Hash: "d"}, age: 91 * 24 * time.Hour, expired: true},
+		{desc: main.Description{Name: "qt", Version: "1", Hash: "e"}, age: 365 * 24 * time.Hour, expired: false},
+	}
+
+	ctx := context.Background()
+	for _, entry := range entries {
+		err := store.Put(ctx, entry.desc, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		mod_time := now.Add(-entry.age)
+		err = os.Chtimes(store.Resolve(entry.desc), mod_time, mod_time)
+		require.NoError(err)
+	}
+
+	retention, err := main.NewRetention(store, &main.RetentionConfig{
+		MaxAge: main.Duration(14 * 24 * time.Hour),
+		Overrides: map[string]main.Duration{
+			"boost": main.Duration(90 * 24 * time.Hour),
+			"qt":    0,
+		},
+	})
+	require.NoError(err)
+
+	n, err := retention.Sweep(ctx, now)
+	require.NoError(err)
+	require.Equal(2, n)
+
+	for _, entry := range entries {
+		_, err := store.Head(ctx, entry.desc)
+		if entry.expired {
+			require.ErrorIs(err, main.ErrNotExist, entry.desc.String())
+		} else {
+			require.NoError(err, entry.desc.String())
+		}
+	}
+}
+
+func TestRetentionSweepUnnamed(t *testing.T) {
+	require := require.New(t)
+
+	store, err := main.NewStore(&main.StoreConfig{Kind: "archives", Path: t.TempDir()})
+	require.NoError(err)
+	defer store.Close()
+
+	desc := main.Description{Name: "boost", Version: "1", Hash: "abcd"}
+	err = store.Put(context.Background(), desc, bytes.NewReader(randomData(t)))
+	require.NoError(err)
+
+	now := time.Now().Add(30 * 24 * time.Hour)
+
+	// The entry could be overridden to be kept longer.
+	retention, err := main.NewRetention(store, &main.RetentionConfig{
+		MaxAge:    main.Duration(14 * 24 * time.Hour),
+		Overrides: map[string]main.Duration{"boost": main.Duration(90 * 24 * time.Hour)},
+	})
+	require.NoError(err)
+
+	_, err = retention.Sweep(context.Background(), now)
+	require.ErrorIs(err, main.ErrUnnamed)
+
+	_, err = store.Head(context.Background(), desc)
+	require.NoError(err)
+
+	retention, err = main.NewRetention(store, &main.RetentionConfig{
+		MaxAge: main.Duration(14 * 24 * time.Hour),
+	})
+	require.NoError(err)
+
+	n, err := retention.Sweep(context.Background(), now)
+	require.NoError(err)
+	require.Equal(1, n)
+}
+
+func TestNewRetention(t *testing.T) {
+	t.Run("store must be able to enumerate its entries", func(t *testing.T) {
+		require := require.New(t)
+
+		store, err := main.NewFsStore(t.TempDir(), main.WithPathResolve(func(desc main.Description) string {
+			return desc.Hash
+		}))
+		require.NoError(err)
+
+		retention, err := main.NewRetention(store, &main.RetentionConfig{})
+		require.NoError(err)
+
+		_, err = retention.Sweep(context.Background(), time.Now())
+		require.ErrorContains(err, "list entries")
+	})
+
+	t.Run("store must implement lister", func(t *testing.T) {
+		require := require.New(t)
+
+		store, err := NewTestFsStore(t)
+		require.NoError(err)
+
+		// Hides methods other than `main.Store`'s.
+		_, err = main.NewRetention(struct{ main.Store }{store}, &main.RetentionConfig{})
+		require.ErrorContains(err, "cannot")
+	})
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	// Default size of the parts of multipart uploads.
+	s3StoreDefaultPartSize = 16 << 20
+
+	// S3 rejects parts smaller than this except the last one.
+	s3StoreMinPartSize = 5 << 20
+)
+
+type s3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+
+	// Size of the parts an upload is split into.
+	// Each part is buffered in memory since the size of the upload is unknown.
+	part_size uint64
+}
+
+type s3Option func(s *s3Store)
+
+func WithS3Prefix(p string) s3Option {
+	return func(s *s3Store) {
+		s.prefix = strings.Trim(p, "/")
+	}
+}
+
+// WithS3PartSize sets the size of the parts an upload is split into.
+func WithS3PartSize(size uint64) s3Option {
+	return func(s *s3Store) {
+		s.part_size = size
+	}
+}
+
+func NewS3Store(client *minio.Client, bucket string, opts ...s3Option) (*s3Store, error) {
+	s := &s3Store{client: client, bucket: bucket, part_size: s3StoreDefaultPartSize}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.bucket == "" {
+		return nil, errors.New("bucket must be specified")
+	}
+	if s.part_size < s3StoreMinPartSize {
+		return nil, errors.New("part size must be at least 5MiB")
+	}
+
+	ok, err := s.client.BucketExists(context.Background(), s.bucket)
+	if err != nil {
+		return nil, fmt.Errorf("check bucket: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("bucket not found: %s", s.bucket)
+	}
+
+	return s, nil
+}
+
+// newS3StoreFromConfig creates a store from options such as:
+//
+//	s3:,bucket=vcpkg,prefix=cache,endpoint=http://localhost:9000,path_style,part_size=16MiB
+func newS3StoreFromConfig(conf *StoreConfig) (*s3Store, error) {
+	endpoint := conf.Opts["endpoint"]
+	if endpoint == "" {
+		endpoint = "https://s3.amazonaws.com"
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parse endpoint: %w", err)
+	}
+
+	opts := &minio.Options{
+		Secure: u.Scheme == "https",
+		Region: conf.Opts["region"],
+	}
+
+	if access_key, ok := conf.Opts["access_key"]; ok {
+		opts.Creds = credentials.NewStaticV4(access_key, conf.Opts["secret_key"], "")
+	} else {
+		opts.Creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		})
+	}
+
+	if path_style, err := conf.Bool("path_style"); err != nil {
+		return nil, err
+	} else if path_style {
+		opts.BucketLookup = minio.BucketLookupPath
+	}
+
+	store_opts := []s3Option{WithS3Prefix(conf.Opts["prefix"])}
+	if v, ok := conf.Opts["part_size"]; ok {
+		size, err := parseSize(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for option part_size: %w", err)
+		}
+
+		store_opts = append(store_opts, WithS3PartSize(uint64(size)))
+	}
+
+	client, err := minio.New(u.Host, opts)
+	if err != nil {
+		return nil, fmt.Errorf("create S3 client: %w", err)
+	}
+
+	return NewS3Store(client, conf.Opts["bucket"], store_opts...)
+}
+
+func (s *s3Store) Resolve(desc Description) string {
+	return path.Join(s.prefix, desc.Name, desc.Version, desc.Hash)
+}
+
+func s3Error(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NotFound":
+		return fmt.Errorf("%w: %s", ErrNotExist, err.Error())
+	}
+
+	return err
+}
+
+func (s *s3Store) Get(ctx context.Context, desc Description) (io.ReadCloser, Info, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, s.Resolve(desc), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, Info{}, s3Error(err)
+	}
+
+	// Object is fetched lazily so errors such as "NoSuchKey"
+	// are reported by the first read.
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, Info{}, s3Error(err)
+	}
+
+	// Object is seekable so range requests are served by ranged GETs to S3.
+	return obj, Info{Size: info.Size, ModTime: info.LastModified, Seekable: true}, nil
+}
+
+func (s *s3Store) Head(ctx context.Context, desc Description) (Info, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, s.Resolve(desc), minio.StatObjectOptions{})
+	if err != nil {
+		return Info{}, s3Error(err)
+	}
+
+	return Info{Size: info.Size, ModTime: info.LastModified, Seekable: true}, nil
+}
+
+// ReadAt reads the part of the object by a ranged GET.
+func (s *s3Store) ReadAt(ctx context.Context, desc Description, p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(off, off+int64(len(p))-1); err != nil {
+		return 0, err
+	}
+
+	obj, err := s.client.GetObject(ctx, s.bucket, s.Resolve(desc), opts)
+	if err != nil {
+		return 0, s3Error(err)
+	}
+	defer obj.Close()
+
+	n, err := io.ReadFull(obj, p)
+	switch {
+	case err == nil:
+		return n, nil
+	case errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF):
+		return n, io.EOF
+	case minio.ToErrorResponse(err).Code == "InvalidRange":
+		// The object ends before the offset.
+		return 0, io.EOF
+	default:
+		return n, s3Error(err)
+	}
+}
+
+func (s *s3Store) Put(ctx context.Context, desc Description, r io.Reader) error {
+	tgt := s.Resolve(desc)
+	if _, err := s.client.StatObject(ctx, s.bucket, tgt, minio.StatObjectOptions{}); err == nil {
+		return ErrExist
+	} else if err := s3Error(err); !errors.Is(err, ErrNotExist) {
+		return fmt.Errorf("stat object: %w", err)
+	}
+
+	// Without the part size, the client buffers a part of 5TiB/10000 for the upload of unknown size.
+	_, err := s.client.PutObject(ctx, s.bucket, tgt, r, -1, minio.PutObjectOptions{
+		ContentType: "application/zip",
+		PartSize:    s.part_size,
+	})
+	if err != nil {
+		return fmt.Errorf("put object: %w", err)
+	}
+
+	return nil
+}
+
+func (s *s3Store) Delete(ctx context.Context, desc Description) error {
+	tgt := s.Resolve(desc)
+
+	// Removal of an object that does not exist succeeds on S3.
+	if _, err := s.client.StatObject(ctx, s.bucket, tgt, minio.StatObjectOptions{}); err != nil {
+		return s3Error(err)
+	}
+
+	if err := s.client.RemoveObject(ctx, s.bucket, tgt, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("remove object: %w", err)
+	}
+
+	return nil
+}
+
+func (s *s3Store) List(ctx context.Context, fn func(entry Entry) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	prefix := objectListPrefix(s.prefix)
+
+	objs := s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	})
+	for obj := range objs {
+		if obj.Err != nil {
+			return fmt.Errorf("list objects: %w", obj.Err)
+		}
+
+		desc, ok := unresolveObjectKey(prefix, obj.Key)
+		if !ok {
+			continue
+		}
+
+		err := fn(Entry{
+			Description: desc,
+			Size:        obj.Size,
+			ModTime:     obj.LastModified,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *s3Store) Close() error {
+	return nil
+}
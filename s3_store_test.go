@@ -0,0 +1,164 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	main "github.com/lesomnus/vcpkg-cache-http"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+func NewTestS3Client(t *testing.T) *minio.Client {
+	return newTestS3Client(t, func(h http.Handler) http.Handler { return h })
+}
+
+// newTestS3Client creates a client of the fake S3 server whose handler is wrapped by `wrap`.
+func newTestS3Client(t *testing.T, wrap func(h http.Handler) http.Handler) *minio.Client {
+	require := require.New(t)
+
+	fake := gofakes3.New(s3mem.New())
+	// Serve over TLS since the fake does not decode streaming signature
+	// which is used for plain HTTP.
+	server := httptest.NewTLSServer(wrap(fake.Server()))
+	t.Cleanup(server.Close)
+
+	u, err := url.Parse(server.URL)
+	require.NoError(err)
+
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4("foo", "bar", ""),
+		Secure:       true,
+		Transport:    server.Client().Transport,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	require.NoError(err)
+
+	err = client.MakeBucket(context.Background(), "vcpkg", minio.MakeBucketOptions{})
+	require.NoError(err)
+
+	return client
+}
+
+type S3StoreSetup struct{}
+
+func (s *S3StoreSetup) New(t *testing.T) (main.Store, error) {
+	return main.NewS3Store(NewTestS3Client(t), "vcpkg", main.WithS3Prefix("cache"))
+}
+
+func TestS3StoreSuite(t *testing.T) {
+	suite.Run(t, &StoreTestSuite{Store: &S3StoreSetup{}})
+}
+
+func TestNewS3Store(t *testing.T) {
+	t.Run("bucket must be specified", func(t *testing.T) {
+		require := require.New(t)
+
+		_, err := main.NewS3Store(NewTestS3Client(t), "")
+		require.ErrorContains(err, "bucket")
+	})
+
+	t.Run("bucket must exist", func(t *testing.T) {
+		require := require.New(t)
+
+		_, err := main.NewS3Store(NewTestS3Client(t), "not-exists")
+		require.ErrorContains(err, "bucket not found")
+	})
+}
+
+func TestS3StoreList(t *testing.T) {
+	require := require.New(t)
+
+	client := NewTestS3Client(t)
+	store, err := main.NewS3Store(client, "vcpkg", main.WithS3Prefix("cache"))
+	require.NoError(err)
+
+	ctx := context.Background()
+	err = store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(t)))
+	require.NoError(err)
+
+	// Objects not in the layout are ignored.
+	_, err = client.PutObject(ctx, "vcpkg", "cache/foo", bytes.NewReader([]byte("foo")), 3, minio.PutObjectOptions{})
+	require.NoError(err)
+	_, err = client.PutObject(ctx, "vcpkg", "other/foo/bar/baz", bytes.NewReader([]byte("foo")), 3, minio.PutObjectOptions{})
+	require.NoError(err)
+
+	entries := []main.Entry{}
+	err = store.List(ctx, func(entry main.Entry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	require.NoError(err)
+	require.Len(entries, 1)
+	require.Equal(DescriptionFoo, entries[0].Description)
+	require.Equal(int64(128), entries[0].Size)
+}
+
+func TestS3StorePutStreams(t *testing.T) {
+	require := require.New(t)
+
+	const part_size = 5 << 20
+	const size = 3*part_size + part_size/2
+
+	// Bytes read from the upload by the client.
+	read := &atomic.Int64{}
+
+	// Bytes read ahead of the parts received by the server.
+	ahead := int64(0)
+	parts := []int64{}
+	client := newTestS3Client(t, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if n, err := strconv.Atoi(r.URL.Query().Get("partNumber")); err == nil {
+				parts = append(parts, r.ContentLength)
+				if d := read.Load() - int64(n)*part_size; d > ahead {
+					ahead = d
+				}
+			}
+			h.ServeHTTP(w, r)
+		})
+	})
+
+	store, err := main.NewS3Store(client, "vcpkg", main.WithS3PartSize(part_size))
+	require.NoError(err)
+
+	// Size of the upload is unknown so the client buffers a part for it.
+	// Without the part size, it is 5TiB/10000 which is larger than 512MiB.
+	body := &readCounter{r: io.LimitReader(rand.Reader, size), n: read}
+	err = store.Put(context.Background(), DescriptionFoo, body)
+	require.NoError(err)
+
+	// The upload is sent by parts and the client does not read further than the next part.
+	require.Equal([]int64{part_size, part_size, part_size, part_size / 2}, parts)
+	require.LessOrEqual(ahead, int64(part_size))
+
+	info, err := store.Head(context.Background(), DescriptionFoo)
+	require.NoError(err)
+	require.Equal(int64(size), info.Size)
+
+	_, err = main.NewS3Store(NewTestS3Client(t), "vcpkg", main.WithS3PartSize(1<<20))
+	require.ErrorContains(err, "part size")
+}
+
+// readCounter counts bytes read from `r` into `n`.
+type readCounter struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (r *readCounter) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
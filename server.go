@@ -1,8 +1,12 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -14,8 +18,23 @@ type Handler struct {
 	Store Store
 	Log   zerolog.Logger
 
-	IsReadable bool
-	IsWritable bool
+	// Collects metrics and serves them at "/metrics" if it is not nil.
+	Metrics *Metrics
+
+	// Authenticates clients if it is not nil.
+	// Permissions of the client are applied in addition to `IsReadable`, `IsWritable` and `IsDeletable`.
+	Auth Authenticator
+
+	IsReadable  bool
+	IsWritable  bool
+	IsDeletable bool
+
+	// Rejects uploads that are not package archives built by vcpkg if it is true.
+	IsValidating bool
+
+	// Directory where uploads are spooled to be validated.
+	// The temporary directory of the system is used if it is empty, which can be a small tmpfs.
+	SpoolDir string
 }
 
 func (s *Handler) handleGet(res http.ResponseWriter, req *http.Request, desc Description) error {
@@ -24,32 +43,113 @@ func (s *Handler) handleGet(res http.ResponseWriter, req *http.Request, desc Des
 		return nil
 	}
 
-	err := s.Store.Get(req.Context(), desc, res)
-	if err == nil {
+	r, info, err := s.Store.Get(req.Context(), desc)
+	if err != nil {
+		if errors.Is(err, ErrNotExist) {
+			res.WriteHeader(http.StatusNotFound)
+		} else {
+			res.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return err
+	}
+
+	defer r.Close()
+
+	etag := writeInfoHeader(res, desc, info)
+
+	// Range and conditional requests are handled by `http.ServeContent`.
+	if rs, ok := r.(io.ReadSeeker); ok {
+		http.ServeContent(res, req, "", info.ModTime, rs)
 		return nil
 	}
 
-	if errors.Is(err, ErrNotExist) {
-		res.WriteHeader(http.StatusNotFound)
+	if isNotModified(req, etag, info.ModTime) {
+		res.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	if info.Size >= 0 {
+		res.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
 	}
 
+	res.WriteHeader(http.StatusOK)
+	_, err = io.Copy(res, r)
 	return err
 }
 
-func (s *Handler) handleHead(res http.ResponseWriter, req *http.Request, desc Description) error {
-	size, err := s.Store.Head(req.Context(), desc)
-	if err == nil {
-		res.Header().Add("Content-Length", strconv.FormatInt(int64(size), 10))
-		res.WriteHeader(http.StatusOK)
-		return nil
+// writeInfoHeader sets headers describing the artifact and returns its entity tag.
+func writeInfoHeader(res http.ResponseWriter, desc Description, info Info) string {
+	etag := entityTag(desc, info)
+
+	h := res.Header()
+	h.Set("Content-Type", "application/zip")
+	h.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, desc.Hash))
+	h.Set("ETag", etag)
+	if !info.ModTime.IsZero() {
+		h.Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+
+	return etag
+}
+
+// entityTag returns a strong entity tag of the artifact.
+// The content digest is used if it is known, otherwise the ABI hash is used
+// since the content for an ABI hash is not changed once it is uploaded.
+func entityTag(desc Description, info Info) string {
+	if info.Digest != "" {
+		return `"` + info.Digest + `"`
 	}
+	return `"` + desc.Hash + `"`
+}
+
+// isNotModified evaluates `If-None-Match` and `If-Modified-Since` headers of the request.
+// `If-Modified-Since` is ignored if `If-None-Match` is given.
+func isNotModified(req *http.Request, etag string, mod_time time.Time) bool {
+	if v := req.Header.Get("If-None-Match"); v != "" {
+		for _, tag := range strings.Split(v, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if v := req.Header.Get("If-Modified-Since"); v != "" && !mod_time.IsZero() {
+		t, err := http.ParseTime(v)
+		if err == nil && !mod_time.Truncate(time.Second).After(t) {
+			return true
+		}
+	}
+
+	return false
+}
 
+func (s *Handler) handleHead(res http.ResponseWriter, req *http.Request, desc Description) error {
+	info, err := s.Store.Head(req.Context(), desc)
 	if errors.Is(err, ErrNotExist) {
 		res.WriteHeader(http.StatusNotFound)
 		return nil
 	}
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
 
-	return err
+	etag := writeInfoHeader(res, desc, info)
+	if isNotModified(req, etag, info.ModTime) {
+		res.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	if info.Size >= 0 {
+		res.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	if info.Seekable {
+		res.Header().Set("Accept-Ranges", "bytes")
+	}
+
+	res.WriteHeader(http.StatusOK)
+	return nil
 }
 
 func (s *Handler) handlePut(res http.ResponseWriter, req *http.Request, desc Description) error {
@@ -58,7 +158,27 @@ func (s *Handler) handlePut(res http.ResponseWriter, req *http.Request, desc Des
 		return nil
 	}
 
-	err := s.Store.Put(req.Context(), desc, req.Body)
+	body := io.Reader(req.Body)
+	if s.IsValidating {
+		f, err := s.spoolValidated(req, desc)
+		if f != nil {
+			defer os.Remove(f.Name())
+			defer f.Close()
+		}
+		if errors.Is(err, ErrInvalidArchive) {
+			zerolog.Ctx(req.Context()).Warn().Err(err).Msg("upload rejected")
+			http.Error(res, err.Error(), http.StatusUnprocessableEntity)
+			return nil
+		}
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+			return err
+		}
+
+		body = f
+	}
+
+	err := s.Store.Put(req.Context(), desc, body)
 	if err == nil {
 		res.WriteHeader(http.StatusOK)
 		return nil
@@ -69,12 +189,164 @@ func (s *Handler) handlePut(res http.ResponseWriter, req *http.Request, desc Des
 		return nil
 	}
 
+	res.WriteHeader(http.StatusInternalServerError)
+	return err
+}
+
+// spoolValidated writes the request body to a temporary file in `SpoolDir` and validates it as a package archive.
+// The returned file is rewound to the beginning and must be removed by the caller.
+func (s *Handler) spoolValidated(req *http.Request, desc Description) (*os.File, error) {
+	if s.SpoolDir != "" {
+		if err := os.MkdirAll(s.SpoolDir, 0744); err != nil {
+			return nil, fmt.Errorf("create spool directory: %w", err)
+		}
+	}
+
+	f, err := os.CreateTemp(s.SpoolDir, "vcpkg-cache-http-")
+	if err != nil {
+		return nil, fmt.Errorf("create temp file: %w", err)
+	}
+
+	size, err := io.Copy(f, req.Body)
+	if err != nil {
+		return f, fmt.Errorf("receive: %w", err)
+	}
+	if err := ValidateArchive(f, size, desc.Name); err != nil {
+		return f, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return f, err
+	}
+
+	return f, nil
+}
+
+func (s *Handler) handleDelete(res http.ResponseWriter, req *http.Request, desc Description) error {
+	if !s.IsDeletable {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	err := s.Store.Delete(req.Context(), desc)
+	if err == nil {
+		res.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	if errors.Is(err, ErrNotExist) {
+		res.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	res.WriteHeader(http.StatusInternalServerError)
+	return err
+}
+
+type entryResponse struct {
+	Name    string    `json:"name"`
+	Version string    `json:"version"`
+	Hash    string    `json:"hash"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+
+	Digest   string `json:"digest,omitempty"`
+	Uploader string `json:"uploader,omitempty"`
+}
+
+// handleListEntries responds entries in the store as JSON array.
+// Entries can be filtered by query parameters "name" and "version"
+// unless the store does not record them.
+func (s *Handler) handleListEntries(res http.ResponseWriter, req *http.Request) error {
+	if req.Method != http.MethodGet {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	}
+	if !s.IsReadable {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	lister, ok := s.Store.(Lister)
+	if !ok {
+		res.WriteHeader(http.StatusNotImplemented)
+		return nil
+	}
+
+	query := req.URL.Query()
+	name := query.Get("name")
+	version := query.Get("version")
+
+	res.Header().Set("Content-Type", "application/json")
+
+	// Entries are streamed since there can be a lot.
+	is_first := true
+	enc := json.NewEncoder(res)
+	err := lister.List(req.Context(), func(entry Entry) error {
+		if (name != "" || version != "") && entry.Name == "" {
+			return ErrUnnamed
+		}
+		if name != "" && entry.Name != name {
+			return nil
+		}
+		if version != "" && entry.Version != version {
+			return nil
+		}
+
+		delim := ","
+		if is_first {
+			delim = "["
+			is_first = false
+		}
+		if _, err := res.Write([]byte(delim)); err != nil {
+			return err
+		}
+
+		return enc.Encode(entryResponse{
+			Name:    entry.Name,
+			Version: entry.Version,
+			Hash:    entry.Hash,
+			Size:    entry.Size,
+			ModTime: entry.ModTime,
+
+			Digest:   entry.Digest,
+			Uploader: entry.Uploader,
+		})
+	})
+	if err != nil {
+		if is_first {
+			if errors.Is(err, ErrUnnamed) {
+				http.Error(res, err.Error(), http.StatusBadRequest)
+			} else {
+				res.WriteHeader(http.StatusInternalServerError)
+			}
+		}
+		return err
+	}
+
+	if is_first {
+		_, err = res.Write([]byte("[]\n"))
+	} else {
+		_, err = res.Write([]byte("]\n"))
+	}
+
 	return err
 }
 
+func (s *Handler) handleApi(res http.ResponseWriter, req *http.Request) error {
+	switch req.URL.Path {
+	case "/_api/entries":
+		return s.handleListEntries(res, req)
+
+	default:
+		res.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+}
+
 type responseWriter struct {
 	http.ResponseWriter
 	status_code int
+	size        int64
 }
 
 func (w *responseWriter) WriteHeader(statusCode int) {
@@ -82,6 +354,23 @@ func (w *responseWriter) WriteHeader(statusCode int) {
 	w.ResponseWriter.WriteHeader(statusCode)
 }
 
+func (w *responseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+type countingReader struct {
+	io.ReadCloser
+	size int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.size += int64(n)
+	return n, err
+}
+
 func (s *Handler) parseDescription(res http.ResponseWriter, req *http.Request) (Description, error) {
 	entries := strings.SplitN(req.URL.Path[1:], "/", 4)
 	if len(entries) != 3 {
@@ -93,6 +382,7 @@ func (s *Handler) parseDescription(res http.ResponseWriter, req *http.Request) (
 	case http.MethodGet:
 	case http.MethodHead:
 	case http.MethodPut:
+	case http.MethodDelete:
 		break
 
 	default:
@@ -100,11 +390,65 @@ func (s *Handler) parseDescription(res http.ResponseWriter, req *http.Request) (
 		return Description{}, errors.New("invalid method")
 	}
 
-	return Description{
+	desc := Description{
 		Name:    entries[0],
 		Version: entries[1],
 		Hash:    entries[2],
-	}, nil
+	}
+	if desc.Name == "" || desc.Version == "" || desc.Hash == "" {
+		res.WriteHeader(http.StatusBadRequest)
+		return Description{}, fmt.Errorf("%w: empty segment", ErrInvalidDescription)
+	}
+	if err := desc.Validate(); err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		return Description{}, err
+	}
+
+	return desc, nil
+}
+
+func requiredPermission(method string) Permission {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return PermRead
+	case http.MethodPut:
+		return PermWrite
+	case http.MethodDelete:
+		return PermDelete
+	default:
+		return PermNone
+	}
+}
+
+// authenticate identifies the client and checks whether the client has the permission.
+// Returned request has the client in its context.
+// It writes the response and returns an error if the client is not permitted.
+func (s *Handler) authenticate(res http.ResponseWriter, req *http.Request, perm Permission) (*http.Request, error) {
+	if s.Auth == nil {
+		return req, nil
+	}
+
+	client, err := s.Auth.Authenticate(req)
+	if err != nil {
+		if errors.Is(err, ErrUnauthorized) {
+			for _, challenge := range s.Auth.Challenges() {
+				res.Header().Add("WWW-Authenticate", challenge)
+			}
+			res.WriteHeader(http.StatusUnauthorized)
+		} else {
+			res.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return req, fmt.Errorf("authenticate: %w", err)
+	}
+
+	req = req.WithContext(withClient(req.Context(), client))
+	if !client.Permission.Has(perm) {
+		res.WriteHeader(http.StatusForbidden)
+		return req, fmt.Errorf("permission denied for %s", client.Name)
+	}
+
+	return req, nil
 }
 
 func getRemoteAddr(req *http.Request) string {
@@ -127,13 +471,50 @@ func (s *Handler) ServeHTTP(r http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if (req.URL.Path == "/metrics") && (s.Metrics != nil) {
+		if _, err := s.authenticate(r, req, PermRead); err != nil {
+			s.Log.Warn().Str("remote_addr", remote_addr).Msg("metrics " + err.Error())
+			return
+		}
+
+		s.Metrics.Handler().ServeHTTP(r, req)
+		return
+	}
+
 	t0 := time.Now()
-	res := &responseWriter{r, http.StatusOK}
+	res := &responseWriter{ResponseWriter: r, status_code: http.StatusOK}
 
 	l := s.Log.With().Str("_", getTicket()).Logger()
 	req = req.WithContext(l.WithContext(req.Context()))
 
+	if strings.HasPrefix(req.URL.Path, "/_api/") {
+		l := l.With().
+			Str("remote_addr", remote_addr).
+			Str("url", req.URL.String()).
+			Str("method", req.Method).
+			Logger()
+
+		req, err := s.authenticate(res, req, PermRead)
+		if err != nil {
+			l.Warn().Dur("dt", time.Since(t0)).Int("status", res.status_code).Msg("REQ " + err.Error())
+			return
+		}
+
+		l.Info().Msg("REQ API")
+
+		err = s.handleApi(res, req)
+		s.logResponse(l, t0, res, "RES API", err)
+		return
+	}
+
+	body := &countingReader{ReadCloser: req.Body}
+	req.Body = body
+
 	desc, err := s.parseDescription(res, req)
+	is_malformed := err != nil
+	if err == nil {
+		req, err = s.authenticate(res, req, requiredPermission(req.Method))
+	}
 	{
 		l := l.With().
 			Str("remote_addr", remote_addr).
@@ -143,12 +524,25 @@ func (s *Handler) ServeHTTP(r http.ResponseWriter, req *http.Request) {
 
 		if err != nil {
 			l.Warn().Dur("dt", time.Since(t0)).Int("status", res.status_code).Msg("REQ " + err.Error())
+			if s.Metrics != nil {
+				// Malformed requests are errors rather than misses
+				// while rejections by the authentication are neither.
+				var observed error
+				if is_malformed {
+					observed = err
+				}
+				s.Metrics.Observe(req.Method, res.status_code, observed, time.Since(t0), res.size, body.size)
+			}
 			return
 		}
 
 		l.Info().Msg("")
 	}
 
+	if client, ok := ClientFromContext(req.Context()); ok {
+		l = l.With().Str("client", client.Name).Logger()
+	}
+
 	l.Info().
 		Str("name", desc.Name).
 		Str("version", desc.Version).
@@ -165,10 +559,19 @@ func (s *Handler) ServeHTTP(r http.ResponseWriter, req *http.Request) {
 
 	case http.MethodPut:
 		err = s.handlePut(res, req, desc)
+
+	case http.MethodDelete:
+		err = s.handleDelete(res, req, desc)
 	}
 
+	s.logResponse(l, t0, res, "RES "+req.Method, err)
+	if s.Metrics != nil {
+		s.Metrics.Observe(req.Method, res.status_code, err, time.Since(t0), res.size, body.size)
+	}
+}
+
+func (s *Handler) logResponse(l zerolog.Logger, t0 time.Time, res *responseWriter, msg string, err error) {
 	l = l.With().Dur("dt", time.Since(t0)).Int("status", res.status_code).Logger()
-	msg := "RES " + req.Method
 
 	if err != nil {
 		l.Error().Err(err).Msg(msg)
@@ -4,11 +4,17 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
+	"time"
 
 	main "github.com/lesomnus/vcpkg-cache-http"
 	"github.com/rs/zerolog"
@@ -19,15 +25,16 @@ func WithHandler(f func(t *testing.T, store main.Store, handler *main.Handler))
 	return func(t *testing.T) {
 		require := require.New(t)
 
-		store, err := NewTestFsStore(t)
+		store, err := NewTestMemoryStore(t)
 		require.NoError(err)
 
 		handler := main.Handler{
 			Store: store,
 			Log:   zerolog.New(io.Discard),
 
-			IsReadable: true,
-			IsWritable: true,
+			IsReadable:  true,
+			IsWritable:  true,
+			IsDeletable: true,
 		}
 
 		f(t, store, &handler)
@@ -75,12 +82,34 @@ func TestServerGet(t *testing.T) {
 		require := require.New(t)
 
 		ctx := context.Background()
-		err := store.Get(ctx, DescriptionFoo, io.Discard)
+		_, _, err := store.Get(ctx, DescriptionFoo)
 		require.ErrorIs(err, main.ErrNotExist)
 
 		require.HTTPStatusCode(handler.ServeHTTP, http.MethodGet, DescriptionFoo.String(), nil, http.StatusNotFound)
 	}))
 
+	t.Run("500 if cache corrupted", func(t *testing.T) {
+		require := require.New(t)
+
+		store, err := main.NewFsStore(t.TempDir())
+		require.NoError(err)
+
+		err = store.Put(context.Background(), DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+		err = os.Truncate(store.Resolve(DescriptionFoo), 0)
+		require.NoError(err)
+
+		handler := &main.Handler{
+			Store: store,
+			Log:   zerolog.New(io.Discard),
+
+			IsReadable: true,
+		}
+
+		require.HTTPStatusCode(handler.ServeHTTP, http.MethodGet, DescriptionFoo.String(), nil, http.StatusInternalServerError)
+		require.HTTPStatusCode(handler.ServeHTTP, http.MethodGet, DescriptionFoo.String(), nil, http.StatusNotFound)
+	})
+
 	t.Run("404 if path invalid", WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
 		require := require.New(t)
 
@@ -109,6 +138,175 @@ func TestServerGet(t *testing.T) {
 	}))
 }
 
+// unseekableStore hides `io.Seeker` of the readers from the store.
+type unseekableStore struct {
+	main.Store
+}
+
+func (s *unseekableStore) Get(ctx context.Context, desc main.Description) (io.ReadCloser, main.Info, error) {
+	r, info, err := s.Store.Get(ctx, desc)
+	if err != nil {
+		return nil, info, err
+	}
+
+	return struct{ io.ReadCloser }{r}, info, nil
+}
+
+func TestServerGetHeader(t *testing.T) {
+	test_cases := []struct {
+		desc string
+		wrap func(store main.Store) main.Store
+	}{
+		{desc: "seekable", wrap: func(store main.Store) main.Store { return store }},
+		{desc: "unseekable", wrap: func(store main.Store) main.Store { return &unseekableStore{store} }},
+	}
+	for _, tc := range test_cases {
+		t.Run(tc.desc, WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+			require := require.New(t)
+
+			data := randomData(t)
+			err := store.Put(context.Background(), DescriptionFoo, bytes.NewReader(data))
+			require.NoError(err)
+
+			handler.Store = tc.wrap(store)
+
+			do := func(method string) *http.Response {
+				req := httptest.NewRequest(method, DescriptionFoo.String(), nil)
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+				return w.Result()
+			}
+
+			res_get := do(http.MethodGet)
+			require.Equal(http.StatusOK, res_get.StatusCode)
+			require.Equal(strconv.Itoa(len(data)), res_get.Header.Get("Content-Length"))
+			require.Equal("application/zip", res_get.Header.Get("Content-Type"))
+			require.Equal(`attachment; filename="baz.zip"`, res_get.Header.Get("Content-Disposition"))
+
+			received, err := io.ReadAll(res_get.Body)
+			require.NoError(err)
+			require.Equal(data, received)
+
+			res_head := do(http.MethodHead)
+			require.Equal(http.StatusOK, res_head.StatusCode)
+			for _, key := range []string{"Content-Length", "Content-Type", "Content-Disposition", "ETag", "Last-Modified"} {
+				require.NotEmpty(res_get.Header.Get(key), key)
+				require.Equal(res_get.Header.Get(key), res_head.Header.Get(key), key)
+			}
+		}))
+	}
+}
+
+func TestServerGetRange(t *testing.T) {
+	t.Run("206 if range is given", WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		data := randomData(t)
+		err := store.Put(context.Background(), DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		req := httptest.NewRequest(http.MethodGet, DescriptionFoo.String(), nil)
+		req.Header.Set("Range", "bytes=100-")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		res := w.Result()
+		require.Equal(http.StatusPartialContent, res.StatusCode)
+		require.Equal("bytes 100-127/128", res.Header.Get("Content-Range"))
+
+		received, err := io.ReadAll(res.Body)
+		require.NoError(err)
+		require.Equal(data[100:], received)
+	}))
+
+	t.Run("200 if artifact is modified since the given time", WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		data := randomData(t)
+		err := store.Put(context.Background(), DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		req := httptest.NewRequest(http.MethodGet, DescriptionFoo.String(), nil)
+		req.Header.Set("Range", "bytes=100-")
+		req.Header.Set("If-Range", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		res := w.Result()
+		require.Equal(http.StatusOK, res.StatusCode)
+
+		received, err := io.ReadAll(res.Body)
+		require.NoError(err)
+		require.Equal(data, received)
+	}))
+
+	t.Run("416 if range is not satisfiable", WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		err := store.Put(context.Background(), DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		req := httptest.NewRequest(http.MethodGet, DescriptionFoo.String(), nil)
+		req.Header.Set("Range", "bytes=1000-")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		require.Equal(http.StatusRequestedRangeNotSatisfiable, w.Result().StatusCode)
+	}))
+}
+
+func TestServerConditional(t *testing.T) {
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		t.Run(method, WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+			require := require.New(t)
+
+			data := randomData(t)
+			err := store.Put(context.Background(), DescriptionFoo, bytes.NewReader(data))
+			require.NoError(err)
+
+			do := func(header http.Header) *http.Response {
+				req := httptest.NewRequest(method, DescriptionFoo.String(), nil)
+				for k, v := range header {
+					req.Header[k] = v
+				}
+
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+				return w.Result()
+			}
+
+			res := do(nil)
+			require.Equal(http.StatusOK, res.StatusCode)
+
+			digest := sha256.Sum256(data)
+			etag := `"` + hex.EncodeToString(digest[:]) + `"`
+			require.Equal(etag, res.Header.Get("ETag"))
+
+			last_modified := res.Header.Get("Last-Modified")
+			require.NotEmpty(last_modified)
+
+			res = do(http.Header{"If-None-Match": {etag}})
+			require.Equal(http.StatusNotModified, res.StatusCode)
+
+			res = do(http.Header{"If-None-Match": {`"foo", ` + etag}})
+			require.Equal(http.StatusNotModified, res.StatusCode)
+
+			res = do(http.Header{"If-None-Match": {`"foo"`}})
+			require.Equal(http.StatusOK, res.StatusCode)
+
+			res = do(http.Header{"If-Modified-Since": {last_modified}})
+			require.Equal(http.StatusNotModified, res.StatusCode)
+
+			res = do(http.Header{"If-Modified-Since": {time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)}})
+			require.Equal(http.StatusOK, res.StatusCode)
+
+			// `If-Modified-Since` is ignored if `If-None-Match` is given.
+			res = do(http.Header{"If-None-Match": {`"foo"`}, "If-Modified-Since": {last_modified}})
+			require.Equal(http.StatusOK, res.StatusCode)
+		}))
+	}
+}
+
 func TestServerHead(t *testing.T) {
 	t.Run("200 if cache exists", WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
 		require := require.New(t)
@@ -125,6 +323,23 @@ func TestServerHead(t *testing.T) {
 		res := w.Result()
 		require.Equal(http.StatusOK, res.StatusCode)
 		require.Equal(strconv.FormatInt(int64(len(data)), 10), res.Header.Get("Content-Length"))
+		require.Equal("bytes", res.Header.Get("Accept-Ranges"))
+	}))
+
+	t.Run("ranges are not advertised if store cannot seek", WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		handler.Store = main.NewZstdStore(store)
+		err := handler.Store.Put(context.Background(), DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		req := httptest.NewRequest(http.MethodHead, DescriptionFoo.String(), nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		res := w.Result()
+		require.Equal(http.StatusOK, res.StatusCode)
+		require.Empty(res.Header.Get("Accept-Ranges"))
 	}))
 
 	t.Run("404 if cache not exists", WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
@@ -150,10 +365,9 @@ func TestServerPut(t *testing.T) {
 		res := w.Result()
 		require.Equal(http.StatusOK, res.StatusCode)
 
-		var received bytes.Buffer
-		err := store.Get(context.Background(), DescriptionFoo, &received)
+		received, err := readAll(context.Background(), store, DescriptionFoo)
 		require.NoError(err)
-		require.Equal(data, received.Bytes())
+		require.Equal(data, received)
 	}))
 
 	t.Run("405 if not readable", WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
@@ -169,7 +383,7 @@ func TestServerPut(t *testing.T) {
 		res := w.Result()
 		require.Equal(http.StatusMethodNotAllowed, res.StatusCode)
 
-		err := store.Get(context.Background(), DescriptionFoo, io.Discard)
+		_, _, err := store.Get(context.Background(), DescriptionFoo)
 		require.ErrorIs(err, main.ErrNotExist)
 	}))
 
@@ -197,6 +411,357 @@ func TestServerPut(t *testing.T) {
 	}))
 }
 
+func TestServerDelete(t *testing.T) {
+	t.Run("cache will miss after DELETE", WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		ctx := context.Background()
+		err := store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		require.HTTPStatusCode(handler.ServeHTTP, http.MethodDelete, DescriptionFoo.String(), nil, http.StatusOK)
+
+		_, err = store.Head(ctx, DescriptionFoo)
+		require.ErrorIs(err, main.ErrNotExist)
+	}))
+
+	t.Run("404 if cache not exists", WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+		require.HTTPStatusCode(handler.ServeHTTP, http.MethodDelete, DescriptionFoo.String(), nil, http.StatusNotFound)
+	}))
+
+	t.Run("405 if not deletable", WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		ctx := context.Background()
+		err := store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		handler.IsDeletable = false
+		require.HTTPStatusCode(handler.ServeHTTP, http.MethodDelete, DescriptionFoo.String(), nil, http.StatusMethodNotAllowed)
+
+		_, err = store.Head(ctx, DescriptionFoo)
+		require.NoError(err)
+	}))
+}
+
+func TestServerStoreFailure(t *testing.T) {
+	methods := []string{
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodPut,
+		http.MethodDelete,
+	}
+	for _, method := range methods {
+		t.Run("500 on "+method, WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+			require := require.New(t)
+
+			handler.Store = &brokenStore{store}
+			require.HTTPStatusCode(handler.ServeHTTP, method, DescriptionFoo.String(), nil, http.StatusInternalServerError)
+		}))
+	}
+
+	t.Run("500 on PUT of valid archive", func(t *testing.T) {
+		require := require.New(t)
+
+		handler := &main.Handler{
+			Store: &brokenStore{},
+			Log:   zerolog.New(io.Discard),
+
+			IsWritable:   true,
+			IsValidating: true,
+		}
+
+		data := newTestPackage(t, DescriptionFoo.Name)
+		req := httptest.NewRequest(http.MethodPut, DescriptionFoo.String(), bytes.NewReader(data))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(http.StatusInternalServerError, w.Result().StatusCode)
+	})
+}
+
+func TestServerPathTraversal(t *testing.T) {
+	require := require.New(t)
+
+	// Victim is placed where the traversal from the root of the store reaches.
+	base := t.TempDir()
+	root := filepath.Join(base, "a", "b")
+	victim := filepath.Join(base, "victim")
+	err := os.WriteFile(victim, []byte("victim"), 0644)
+	require.NoError(err)
+
+	store, err := main.NewFsStore(root)
+	require.NoError(err)
+
+	handler := &main.Handler{
+		Store: store,
+		Log:   zerolog.New(io.Discard),
+
+		IsReadable:  true,
+		IsWritable:  true,
+		IsDeletable: true,
+	}
+
+	paths := []string{
+		"/../../victim",
+		"/./../victim",
+		"/foo/../victim",
+		"/foo/bar/..",
+		"/foo/bar/.",
+		"/foo/bar\\..\\..\\victim/baz",
+		"/foo//baz",
+		"//bar/baz",
+	}
+	for _, path := range paths {
+		for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete} {
+			req := httptest.NewRequest(method, "/", bytes.NewReader(randomData(t)))
+			req.URL.Path = path
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			require.Equal(http.StatusBadRequest, w.Result().StatusCode, "%s %s", method, path)
+		}
+	}
+
+	data, err := os.ReadFile(victim)
+	require.NoError(err)
+	require.Equal("victim", string(data))
+
+	entries, err := os.ReadDir(base)
+	require.NoError(err)
+	require.Len(entries, 2)
+}
+
+func TestServerListEntries(t *testing.T) {
+	descs := []main.Description{
+		{Name: "zlib", Version: "1.2.13", Hash: "a"},
+		{Name: "zlib", Version: "1.3.0", Hash: "b"},
+		{Name: "boost", Version: "1.82.0", Hash: "c"},
+	}
+
+	WithEntries := func(f func(t *testing.T, store main.Store, handler *main.Handler)) func(*testing.T) {
+		return WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+			require := require.New(t)
+
+			for _, desc := range descs {
+				err := store.Put(context.Background(), desc, bytes.NewReader(randomData(t)))
+				require.NoError(err)
+			}
+
+			f(t, store, handler)
+		})
+	}
+
+	list := func(t *testing.T, handler *main.Handler, target string) []map[string]any {
+		require := require.New(t)
+
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		res := w.Result()
+		require.Equal(http.StatusOK, res.StatusCode)
+		require.Equal("application/json", res.Header.Get("Content-Type"))
+
+		entries := []map[string]any{}
+		err := json.NewDecoder(res.Body).Decode(&entries)
+		require.NoError(err)
+
+		return entries
+	}
+
+	t.Run("all entries", WithEntries(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		entries := list(t, handler, "/_api/entries")
+		require.Len(entries, len(descs))
+		require.Equal("boost", entries[0]["name"])
+		require.Equal("1.82.0", entries[0]["version"])
+		require.Equal("c", entries[0]["hash"])
+		require.Equal(float64(128), entries[0]["size"])
+		require.Contains(entries[0], "mod_time")
+		require.Contains(entries[0], "digest")
+	}))
+
+	t.Run("entries filtered by name", WithEntries(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		entries := list(t, handler, "/_api/entries?name=zlib")
+		require.Len(entries, 2)
+		for _, entry := range entries {
+			require.Equal("zlib", entry["name"])
+		}
+
+		entries = list(t, handler, "/_api/entries?name=zlib&version=1.3.0")
+		require.Len(entries, 1)
+		require.Equal("b", entries[0]["hash"])
+	}))
+
+	t.Run("empty array if no entry", WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		entries := list(t, handler, "/_api/entries")
+		require.Empty(entries)
+	}))
+
+	t.Run("405 if not readable", WithEntries(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		handler.IsReadable = false
+		require.HTTPStatusCode(handler.ServeHTTP, http.MethodGet, "/_api/entries", nil, http.StatusMethodNotAllowed)
+	}))
+
+	t.Run("405 if method is not GET", WithEntries(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+		require.HTTPStatusCode(handler.ServeHTTP, http.MethodPut, "/_api/entries", nil, http.StatusMethodNotAllowed)
+	}))
+
+	t.Run("404 if API not exists", WithEntries(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+		require.HTTPStatusCode(handler.ServeHTTP, http.MethodGet, "/_api/foo", nil, http.StatusNotFound)
+	}))
+
+	t.Run("400 if filtered but store does not record names", WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		archives, err := main.NewStore(&main.StoreConfig{Kind: "archives", Path: t.TempDir()})
+		require.NoError(err)
+		defer archives.Close()
+
+		err = archives.Put(context.Background(), main.Description{Name: "zlib", Version: "1.3.0", Hash: "abcd"}, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		handler.Store = archives
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/_api/entries?name=zlib", nil))
+		require.Equal(http.StatusBadRequest, w.Code)
+
+		entries := list(t, handler, "/_api/entries")
+		require.Len(entries, 1)
+		require.Equal("abcd", entries[0]["hash"])
+	}))
+
+	t.Run("501 if store cannot enumerate its entries", WithEntries(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		handler.Store = struct{ main.Store }{store}
+		require.HTTPStatusCode(handler.ServeHTTP, http.MethodGet, "/_api/entries", nil, http.StatusNotImplemented)
+	}))
+}
+
+func TestServerAuth(t *testing.T) {
+	WithAuth := func(f func(t *testing.T, store main.Store, handler *main.Handler)) func(*testing.T) {
+		return WithHandler(func(t *testing.T, store main.Store, handler *main.Handler) {
+			require := require.New(t)
+
+			auth, err := main.NewAuthenticator(&main.AuthConfig{
+				ReadTokens:   map[string]string{"reader": "r-token"},
+				WriteTokens:  map[string]string{"writer": "w-token"},
+				DeleteTokens: map[string]string{"deleter": "d-token"},
+			})
+			require.NoError(err)
+
+			handler.Auth = auth
+			f(t, store, handler)
+		})
+	}
+
+	request := func(handler *main.Handler, method string, target string, token string) *http.Response {
+		req := httptest.NewRequest(method, target, bytes.NewReader([]byte("foo")))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	t.Run("401 if no credentials", WithAuth(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete} {
+			res := request(handler, method, DescriptionFoo.String(), "")
+			require.Equal(http.StatusUnauthorized, res.StatusCode)
+			require.Contains(res.Header.Get("WWW-Authenticate"), "Bearer")
+		}
+
+		res := request(handler, http.MethodGet, "/_api/entries", "")
+		require.Equal(http.StatusUnauthorized, res.StatusCode)
+	}))
+
+	t.Run("401 if invalid token", WithAuth(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		res := request(handler, http.MethodGet, DescriptionFoo.String(), "foo")
+		require.Equal(http.StatusUnauthorized, res.StatusCode)
+	}))
+
+	t.Run("probe does not require credentials", WithAuth(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		res := request(handler, http.MethodGet, "/", "")
+		require.Equal(http.StatusOK, res.StatusCode)
+	}))
+
+	t.Run("reader can read but cannot write", WithAuth(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		err := store.Put(context.Background(), DescriptionFoo, bytes.NewReader([]byte("foo")))
+		require.NoError(err)
+
+		res := request(handler, http.MethodGet, DescriptionFoo.String(), "r-token")
+		require.Equal(http.StatusOK, res.StatusCode)
+
+		res = request(handler, http.MethodHead, DescriptionFoo.String(), "r-token")
+		require.Equal(http.StatusOK, res.StatusCode)
+
+		res = request(handler, http.MethodGet, "/_api/entries", "r-token")
+		require.Equal(http.StatusOK, res.StatusCode)
+
+		res = request(handler, http.MethodPut, "/foo/bar/qux", "r-token")
+		require.Equal(http.StatusForbidden, res.StatusCode)
+
+		res = request(handler, http.MethodDelete, DescriptionFoo.String(), "r-token")
+		require.Equal(http.StatusForbidden, res.StatusCode)
+	}))
+
+	t.Run("writer can write but cannot read or delete", WithAuth(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		res := request(handler, http.MethodPut, DescriptionFoo.String(), "w-token")
+		require.Equal(http.StatusOK, res.StatusCode)
+
+		res = request(handler, http.MethodGet, DescriptionFoo.String(), "w-token")
+		require.Equal(http.StatusForbidden, res.StatusCode)
+
+		res = request(handler, http.MethodDelete, DescriptionFoo.String(), "w-token")
+		require.Equal(http.StatusForbidden, res.StatusCode)
+	}))
+
+	t.Run("deleter can delete but cannot write", WithAuth(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		err := store.Put(context.Background(), DescriptionFoo, bytes.NewReader([]byte("foo")))
+		require.NoError(err)
+
+		res := request(handler, http.MethodPut, "/foo/bar/qux", "d-token")
+		require.Equal(http.StatusForbidden, res.StatusCode)
+
+		res = request(handler, http.MethodDelete, DescriptionFoo.String(), "d-token")
+		require.Equal(http.StatusOK, res.StatusCode)
+	}))
+
+	t.Run("global restriction is applied to permitted client", WithAuth(func(t *testing.T, store main.Store, handler *main.Handler) {
+		require := require.New(t)
+
+		handler.IsWritable = false
+
+		res := request(handler, http.MethodPut, DescriptionFoo.String(), "w-token")
+		require.Equal(http.StatusMethodNotAllowed, res.StatusCode)
+	}))
+}
+
 func TestServerInvalidMethod(t *testing.T) {
 	require := require.New(t)
 	methods := []string{
@@ -205,7 +770,7 @@ func TestServerInvalidMethod(t *testing.T) {
 		http.MethodPost,
 		// http.MethodPut,
 		http.MethodPatch,
-		http.MethodDelete,
+		// http.MethodDelete,
 		http.MethodConnect,
 		http.MethodOptions,
 		http.MethodTrace,
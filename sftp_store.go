@@ -0,0 +1,519 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Timeout to establish the SSH connection including the handshake.
+const sftpDialTimeout = 30 * time.Second
+
+// sftpDialer connects to the host and starts a SFTP session on the SSH connection.
+type sftpDialer func() (*sftp.Client, *ssh.Client, error)
+
+// sftpSession is a SFTP session and the SSH connection it runs on.
+type sftpSession struct {
+	client *sftp.Client
+
+	// Closed with the session if it is not nil.
+	conn *ssh.Client
+
+	// Closed when the connection is lost.
+	lost chan struct{}
+}
+
+func newSftpSession(client *sftp.Client, conn *ssh.Client) *sftpSession {
+	session := &sftpSession{client: client, conn: conn, lost: make(chan struct{})}
+	go func() {
+		client.Wait()
+		close(session.lost)
+	}()
+
+	return session
+}
+
+func (s *sftpSession) Close() error {
+	errs := []error{s.client.Close()}
+	if s.conn != nil {
+		errs = append(errs, s.conn.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// sftpStore stores artifacts in a directory of a remote host over SFTP
+// with the same layout as `fsStore`.
+type sftpStore struct {
+	root string
+	work string
+
+	session_mutex sync.Mutex
+	session       *sftpSession
+
+	// Reconnects by `dial` on the next use if the connection is lost; nil if the store cannot.
+	dial sftpDialer
+
+	// Closed with the store, e.g. the connection to the SSH agent.
+	closers []io.Closer
+
+	// Guards directories in the store from being removed
+	// while a file is being moved into there.
+	mutex sync.Mutex
+}
+
+type sftpOption func(s *sftpStore)
+
+// WithSshConn makes the store close the SSH connection when it is closed.
+func WithSshConn(conn *ssh.Client) sftpOption {
+	return func(s *sftpStore) {
+		s.session.conn = conn
+	}
+}
+
+// WithSftpDialer makes the store connect again by `dial` if the connection is lost.
+func WithSftpDialer(dial sftpDialer) sftpOption {
+	return func(s *sftpStore) {
+		s.dial = dial
+	}
+}
+
+// WithSshAgentConn makes the store close the connection to the SSH agent when it is closed.
+func WithSshAgentConn(conn net.Conn) sftpOption {
+	return func(s *sftpStore) {
+		s.closers = append(s.closers, conn)
+	}
+}
+
+func NewSftpStore(client *sftp.Client, root string, opts ...sftpOption) (*sftpStore, error) {
+	s := &sftpStore{root: root, session: &sftpSession{client: client}}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.session = newSftpSession(client, s.session.conn)
+
+	if s.root == "" {
+		s.root = "."
+	}
+	s.work = path.Join(s.root, ".work")
+
+	if err := client.MkdirAll(s.root); err != nil {
+		return nil, fmt.Errorf("create store directory: %w", err)
+	}
+	if err := client.MkdirAll(s.work); err != nil {
+		return nil, fmt.Errorf("create work directory: %w", err)
+	}
+
+	return s, nil
+}
+
+// client returns the client of the SFTP session.
+// If the connection is lost, the session is replaced by a new one if the store can connect again.
+func (s *sftpStore) client() (*sftp.Client, error) {
+	s.session_mutex.Lock()
+	defer s.session_mutex.Unlock()
+
+	select {
+	case <-s.session.lost:
+	default:
+		return s.session.client, nil
+	}
+	if s.dial == nil {
+		// Operations fail by the lost connection.
+		return s.session.client, nil
+	}
+
+	s.session.Close()
+
+	client, conn, err := s.dial()
+	if err != nil {
+		return nil, fmt.Errorf("reconnect: %w", err)
+	}
+
+	s.session = newSftpSession(client, conn)
+	return client, nil
+}
+
+// newSftpStoreFromConfig creates a store from options such as:
+//
+//	sftp:ci@nas.example.com:22/vcpkg-cache,key_file=~/.ssh/id_ed25519
+//
+// The path is relative to the home directory of the user unless it starts with "//".
+func newSftpStoreFromConfig(conf *StoreConfig) (*sftpStore, error) {
+	u, err := url.Parse("sftp://" + conf.Path)
+	if err != nil {
+		return nil, fmt.Errorf("parse location: %w", err)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("user must be specified")
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("host must be specified")
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	auth, agent_conn, err := sshAuthFromConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	closeAgent := func() {
+		if agent_conn != nil {
+			agent_conn.Close()
+		}
+	}
+
+	known_hosts := conf.Opts["known_hosts"]
+	if known_hosts == "" {
+		known_hosts = "~/.ssh/known_hosts"
+	}
+	known_hosts, err = expandHome(known_hosts)
+	if err != nil {
+		closeAgent()
+		return nil, err
+	}
+
+	host_key_callback, err := knownhosts.New(known_hosts)
+	if err != nil {
+		closeAgent()
+		return nil, fmt.Errorf("read known hosts: %w", err)
+	}
+
+	dial := func() (*sftp.Client, *ssh.Client, error) {
+		conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+			User:            u.User.Username(),
+			Auth:            []ssh.AuthMethod{auth},
+			HostKeyCallback: host_key_callback,
+			Timeout:         sftpDialTimeout,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("connect to %s: %w", addr, err)
+		}
+
+		client, err := sftp.NewClient(conn)
+		if err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("start SFTP session: %w", err)
+		}
+
+		return client, conn, nil
+	}
+
+	client, conn, err := dial()
+	if err != nil {
+		closeAgent()
+		return nil, err
+	}
+
+	opts := []sftpOption{WithSshConn(conn), WithSftpDialer(dial)}
+	if agent_conn != nil {
+		opts = append(opts, WithSshAgentConn(agent_conn))
+	}
+
+	store, err := NewSftpStore(client, strings.TrimPrefix(u.Path, "/"), opts...)
+	if err != nil {
+		client.Close()
+		conn.Close()
+		closeAgent()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// sshAuthFromConfig authenticates by the private key given by "key_file" option
+// or by the SSH agent at `SSH_AUTH_SOCK` if the key is not given.
+// The connection to the agent is returned if it is used, which must be kept open while the auth is used.
+func sshAuthFromConfig(conf *StoreConfig) (ssh.AuthMethod, net.Conn, error) {
+	p, ok := conf.Opts["key_file"]
+	if !ok {
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		if sock == "" {
+			return nil, nil, errors.New("key_file must be given if SSH agent is not available")
+		}
+
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			return nil, nil, fmt.Errorf("connect to SSH agent: %w", err)
+		}
+
+		return ssh.PublicKeysCallback(agent.NewClient(conn).Signers), conn, nil
+	}
+
+	p, err := expandHome(p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read key file: %w", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		var err_passphrase *ssh.PassphraseMissingError
+		if errors.As(err, &err_passphrase) {
+			return nil, nil, errors.New("key protected by passphrase is not supported; use SSH agent instead")
+		}
+		return nil, nil, fmt.Errorf("parse key: %w", err)
+	}
+
+	return ssh.PublicKeys(signer), nil, nil
+}
+
+func expandHome(p string) (string, error) {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("get home directory: %w", err)
+	}
+
+	return filepath.Join(home, p[1:]), nil
+}
+
+func (s *sftpStore) Resolve(desc Description) string {
+	return path.Join(s.root, desc.Name, desc.Version, desc.Hash)
+}
+
+func (s *sftpStore) Get(ctx context.Context, desc Description) (io.ReadCloser, Info, error) {
+	if err := desc.Validate(); err != nil {
+		return nil, Info{}, err
+	}
+
+	client, err := s.client()
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	f, err := client.Open(s.Resolve(desc))
+	if err != nil {
+		return nil, Info{}, fmt.Errorf("open file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, Info{}, fmt.Errorf("stat file: %w", err)
+	}
+
+	return f, Info{Size: info.Size(), ModTime: info.ModTime(), Seekable: true}, nil
+}
+
+func (s *sftpStore) Head(ctx context.Context, desc Description) (Info, error) {
+	if err := desc.Validate(); err != nil {
+		return Info{}, err
+	}
+
+	client, err := s.client()
+	if err != nil {
+		return Info{}, err
+	}
+
+	info, err := client.Stat(s.Resolve(desc))
+	if err != nil {
+		return Info{}, err
+	}
+
+	return Info{Size: info.Size(), ModTime: info.ModTime(), Seekable: true}, nil
+}
+
+// Put uploads the artifact to a temporary file in the work directory
+// and renames it to the target so a partial upload is never served.
+func (s *sftpStore) Put(ctx context.Context, desc Description, r io.Reader) error {
+	if err := desc.Validate(); err != nil {
+		return err
+	}
+
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	tgt := s.Resolve(desc)
+	if _, err := client.Stat(tgt); err == nil {
+		return ErrExist
+	} else if !errors.Is(err, ErrNotExist) {
+		return fmt.Errorf("stat file: %w", err)
+	}
+
+	if err := client.MkdirAll(s.work); err != nil {
+		return fmt.Errorf("create work directory: %w", err)
+	}
+
+	name := make([]byte, 8)
+	if _, err := rand.Read(name); err != nil {
+		return err
+	}
+
+	tmp := path.Join(s.work, hex.EncodeToString(name))
+	f, err := client.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+
+	_, err = f.ReadFrom(r)
+	if _, ok := client.HasExtension("fsync@openssh.com"); err == nil && ok {
+		err = f.Sync()
+	}
+	if err_close := f.Close(); err == nil {
+		err = err_close
+	}
+	if err != nil {
+		client.Remove(tmp)
+		return err
+	}
+
+	if err := s.move(client, tmp, tgt); err != nil {
+		client.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+func (s *sftpStore) move(client *sftp.Client, src string, dst string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := client.MkdirAll(path.Dir(dst)); err != nil {
+		return fmt.Errorf("create target directory: %w", err)
+	}
+
+	// Rename of SFTP fails if the target exists on most servers such as OpenSSH.
+	if err := client.Rename(src, dst); err != nil {
+		if _, err_stat := client.Stat(dst); err_stat == nil {
+			return ErrExist
+		}
+		return fmt.Errorf("move uploaded file to storage: %w", err)
+	}
+
+	return nil
+}
+
+func (s *sftpStore) Delete(ctx context.Context, desc Description) error {
+	if err := desc.Validate(); err != nil {
+		return err
+	}
+
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	tgt := s.Resolve(desc)
+	if err := client.Remove(tgt); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Remove directories of the name and the version if they are empty.
+	dir := path.Dir(tgt)
+	for i := 0; i < 2 && dir != s.root; i++ {
+		if err := client.RemoveDirectory(dir); err != nil {
+			break
+		}
+		dir = path.Dir(dir)
+	}
+
+	return nil
+}
+
+// sftpFS is a directory on the remote host as `fs.FS` to be walked by `walkFiles`.
+type sftpFS struct {
+	client *sftp.Client
+	root   string
+}
+
+func (f *sftpFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	return f.client.Open(path.Join(f.root, name))
+}
+
+// ReadDir reads the directory without opening each entry.
+func (f *sftpFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	infos, err := f.client.ReadDir(path.Join(f.root, name))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]fs.DirEntry, len(infos))
+	for i, info := range infos {
+		entries[i] = fs.FileInfoToDirEntry(info)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
+
+func (s *sftpStore) List(ctx context.Context, fn func(entry Entry) error) error {
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	return walkFiles(&sftpFS{client: client, root: s.root}, func(key string, info fs.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Same layout as `fsStore`.
+		desc, ok := fsStoreDefaultUnresolve(key)
+		if !ok {
+			return nil
+		}
+
+		return fn(Entry{
+			Description: desc,
+			Size:        info.Size(),
+			ModTime:     info.ModTime(),
+		})
+	})
+}
+
+func (s *sftpStore) Close() error {
+	s.session_mutex.Lock()
+	defer s.session_mutex.Unlock()
+
+	errs := []error{s.session.Close()}
+	for _, c := range s.closers {
+		errs = append(errs, c.Close())
+	}
+
+	return errors.Join(errs...)
+}
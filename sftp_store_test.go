@@ -0,0 +1,428 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	main "github.com/lesomnus/vcpkg-cache-http"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+type testSshServer struct {
+	Addr string
+	Root string
+
+	// Paths to the private key of the client and known_hosts file that has the server.
+	KeyFile        string
+	KnownHostsFile string
+
+	mutex sync.Mutex
+	conns []net.Conn
+}
+
+// newTestSshServer starts an SSH server that serves SFTP subsystem at a temporary directory
+// to the client authenticated by the key at `KeyFile`.
+func newTestSshServer(t *testing.T) *testSshServer {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	s := &testSshServer{
+		Root:           filepath.Join(dir, "root"),
+		KeyFile:        filepath.Join(dir, "id_ed25519"),
+		KnownHostsFile: filepath.Join(dir, "known_hosts"),
+	}
+	require.NoError(os.Mkdir(s.Root, 0755))
+
+	_, host_key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+	host_signer, err := ssh.NewSignerFromKey(host_key)
+	require.NoError(err)
+
+	_, client_key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+	client_signer, err := ssh.NewSignerFromKey(client_key)
+	require.NoError(err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(client_key)
+	require.NoError(err)
+	err = os.WriteFile(s.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	require.NoError(err)
+
+	conf := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "ci" && bytes.Equal(key.Marshal(), client_signer.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	conf.AddHostKey(host_signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	t.Cleanup(func() { l.Close() })
+
+	s.Addr = l.Addr().String()
+	err = os.WriteFile(s.KnownHostsFile, []byte(knownhosts.Line([]string{s.Addr}, host_signer.PublicKey())+"\n"), 0644)
+	require.NoError(err)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			s.mutex.Lock()
+			s.conns = append(s.conns, conn)
+			s.mutex.Unlock()
+
+			go s.serve(conn, conf)
+		}
+	}()
+
+	return s
+}
+
+func (s *testSshServer) serve(conn net.Conn, conf *ssh.ServerConfig) {
+	defer conn.Close()
+
+	_, chans, reqs, err := ssh.NewServerConn(conn, conf)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for new_ch := range chans {
+		if new_ch.ChannelType() != "session" {
+			new_ch.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		ch, reqs, err := new_ch.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			for req := range reqs {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+
+				server, err := sftp.NewServer(ch, sftp.WithServerWorkingDirectory(s.Root))
+				if err != nil {
+					ch.Close()
+					return
+				}
+
+				server.Serve()
+				ch.Close()
+			}
+		}()
+	}
+}
+
+// Disconnect closes all connections accepted so far.
+func (s *testSshServer) Disconnect() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testSshServer) Dial() (*sftp.Client, *ssh.Client, error) {
+	data, err := os.ReadFile(s.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := ssh.Dial("tcp", s.Addr, &ssh.ClientConfig{
+		User:            "ci",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return client, conn, nil
+}
+
+func (s *testSshServer) Client(t *testing.T) *sftp.Client {
+	client, conn, err := s.Dial()
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return client
+}
+
+// serveTestSshAgent serves an SSH agent holding the key of the server at `SSH_AUTH_SOCK`.
+// Returned channel is closed when the client closes the connection to the agent.
+func serveTestSshAgent(t *testing.T, server *testSshServer) <-chan struct{} {
+	require := require.New(t)
+
+	data, err := os.ReadFile(server.KeyFile)
+	require.NoError(err)
+	key, err := ssh.ParseRawPrivateKey(data)
+	require.NoError(err)
+
+	keyring := agent.NewKeyring()
+	err = keyring.Add(agent.AddedKey{PrivateKey: key})
+	require.NoError(err)
+
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", sock)
+	require.NoError(err)
+	t.Cleanup(func() { l.Close() })
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Returns when the client closes the connection.
+		agent.ServeAgent(keyring, conn)
+	}()
+
+	t.Setenv("SSH_AUTH_SOCK", sock)
+	return served
+}
+
+type SftpStoreSetup struct{}
+
+func (s *SftpStoreSetup) New(t *testing.T) (main.Store, error) {
+	return main.NewSftpStore(newTestSshServer(t).Client(t), "cache")
+}
+
+func TestSftpStoreSuite(t *testing.T) {
+	suite.Run(t, &StoreTestSuite{Store: &SftpStoreSetup{}})
+}
+
+func TestSftpStore(t *testing.T) {
+	t.Run("upload is moved to the store after it completes", func(t *testing.T) {
+		require := require.New(t)
+
+		server := newTestSshServer(t)
+		store, err := main.NewSftpStore(server.Client(t), "cache")
+		require.NoError(err)
+
+		ctx := context.Background()
+		pr, pw := io.Pipe()
+		done := make(chan error)
+		go func() {
+			done <- store.Put(ctx, DescriptionFoo, pr)
+		}()
+
+		_, err = pw.Write(randomData(t))
+		require.NoError(err)
+
+		// Uploading.
+		_, err = store.Head(ctx, DescriptionFoo)
+		require.ErrorIs(err, main.ErrNotExist)
+
+		require.NoError(pw.Close())
+		require.NoError(<-done)
+
+		_, err = store.Head(ctx, DescriptionFoo)
+		require.NoError(err)
+
+		entries, err := os.ReadDir(filepath.Join(server.Root, "cache", ".work"))
+		require.NoError(err)
+		require.Empty(entries)
+	})
+
+	t.Run("failed upload is removed", func(t *testing.T) {
+		require := require.New(t)
+
+		server := newTestSshServer(t)
+		store, err := main.NewSftpStore(server.Client(t), "cache")
+		require.NoError(err)
+
+		ctx := context.Background()
+		pr, pw := io.Pipe()
+		go func() {
+			pw.Write(randomData(t))
+			pw.CloseWithError(errors.New("broken"))
+		}()
+
+		err = store.Put(ctx, DescriptionFoo, pr)
+		require.ErrorContains(err, "broken")
+
+		_, err = store.Head(ctx, DescriptionFoo)
+		require.ErrorIs(err, main.ErrNotExist)
+
+		entries, err := os.ReadDir(filepath.Join(server.Root, "cache", ".work"))
+		require.NoError(err)
+		require.Empty(entries)
+	})
+
+	t.Run("store reconnects if the connection is lost", func(t *testing.T) {
+		require := require.New(t)
+
+		server := newTestSshServer(t)
+		client, conn, err := server.Dial()
+		require.NoError(err)
+
+		store, err := main.NewSftpStore(client, "cache", main.WithSshConn(conn), main.WithSftpDialer(server.Dial))
+		require.NoError(err)
+		defer store.Close()
+
+		ctx := context.Background()
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		server.Disconnect()
+
+		// Requests made before the loss is noticed may fail.
+		require.Eventually(func() bool {
+			_, err := store.Head(ctx, DescriptionFoo)
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("connection to SSH agent is closed with the store", func(t *testing.T) {
+		require := require.New(t)
+
+		server := newTestSshServer(t)
+		served := serveTestSshAgent(t, server)
+
+		store, err := main.NewStore(&main.StoreConfig{
+			Kind: "sftp",
+			Path: "ci@" + server.Addr + "/" + server.Root + "/cache",
+			Opts: map[string]string{"known_hosts": server.KnownHostsFile},
+		})
+		require.NoError(err)
+
+		select {
+		case <-served:
+			require.FailNow("agent connection is closed before the store is closed")
+		default:
+		}
+
+		require.NoError(store.Close())
+
+		select {
+		case <-served:
+		case <-time.After(5 * time.Second):
+			require.FailNow("agent connection is not closed")
+		}
+	})
+
+	t.Run("connection to SSH agent is closed if the store cannot be created", func(t *testing.T) {
+		require := require.New(t)
+
+		server := newTestSshServer(t)
+		served := serveTestSshAgent(t, server)
+
+		// Home directory cannot be found to expand the path.
+		t.Setenv("HOME", "")
+		_, err := main.NewStore(&main.StoreConfig{
+			Kind: "sftp",
+			Path: "ci@" + server.Addr + "/" + server.Root + "/cache",
+			Opts: map[string]string{"known_hosts": "~/.ssh/known_hosts"},
+		})
+		require.ErrorContains(err, "home directory")
+
+		select {
+		case <-served:
+		case <-time.After(5 * time.Second):
+			require.FailNow("agent connection is not closed")
+		}
+	})
+
+	t.Run("paths escaping the root are rejected", func(t *testing.T) {
+		require := require.New(t)
+
+		server := newTestSshServer(t)
+		store, err := main.NewSftpStore(server.Client(t), "cache/a")
+		require.NoError(err)
+
+		victim := filepath.Join(server.Root, "victim")
+		err = os.WriteFile(victim, []byte("victim"), 0644)
+		require.NoError(err)
+
+		ctx := context.Background()
+		desc := main.Description{Name: "..", Version: "..", Hash: "victim"}
+
+		_, _, err = store.Get(ctx, desc)
+		require.ErrorIs(err, main.ErrInvalidDescription)
+		_, err = store.Head(ctx, desc)
+		require.ErrorIs(err, main.ErrInvalidDescription)
+		err = store.Put(ctx, desc, bytes.NewReader(randomData(t)))
+		require.ErrorIs(err, main.ErrInvalidDescription)
+		err = store.Delete(ctx, desc)
+		require.ErrorIs(err, main.ErrInvalidDescription)
+
+		data, err := os.ReadFile(victim)
+		require.NoError(err)
+		require.Equal("victim", string(data))
+	})
+
+	t.Run("entries are listed", func(t *testing.T) {
+		require := require.New(t)
+
+		server := newTestSshServer(t)
+		store, err := main.NewSftpStore(server.Client(t), "cache")
+		require.NoError(err)
+
+		ctx := context.Background()
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		// Files not in the layout are ignored.
+		err = os.WriteFile(filepath.Join(server.Root, "cache", "README"), []byte("foo"), 0644)
+		require.NoError(err)
+
+		entries := []main.Entry{}
+		err = store.List(ctx, func(entry main.Entry) error {
+			entries = append(entries, entry)
+			return nil
+		})
+		require.NoError(err)
+		require.Len(entries, 1)
+		require.Equal(DescriptionFoo, entries[0].Description)
+		require.Equal(int64(128), entries[0].Size)
+
+		err = store.Delete(ctx, DescriptionFoo)
+		require.NoError(err)
+		require.NoDirExists(filepath.Join(server.Root, "cache", DescriptionFoo.Name))
+	})
+}
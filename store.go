@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"strings"
+	"time"
 )
 
 type Description struct {
@@ -16,10 +18,69 @@ func (d *Description) String() string {
 	return fmt.Sprintf("/%s/%s/%s", d.Name, d.Version, d.Hash)
 }
 
+// Validate reports `ErrInvalidDescription` if any field is "." or "..", or contains a separator
+// so the fields are joined into a path that does not escape the root of the store.
+// Fields can be empty for entries of stores that do not record them, such as `archives` store.
+func (d *Description) Validate() error {
+	for _, v := range []string{d.Name, d.Version, d.Hash} {
+		if v == "." || v == ".." || strings.ContainsAny(v, "/\\") {
+			return fmt.Errorf("%w: %q", ErrInvalidDescription, d.String())
+		}
+	}
+
+	return nil
+}
+
+// Info is metadata of a stored artifact.
+type Info struct {
+	// Size of the artifact in bytes.
+	// It is negative if the store does not know it.
+	Size    int64
+	ModTime time.Time
+
+	// Hex encoded SHA-256 digest of the artifact.
+	// It is empty if the store does not know it.
+	Digest string
+
+	// Seekable is true if the reader returned by `Store.Get` implements `io.Seeker`
+	// so range requests are served.
+	Seekable bool
+}
+
 type Store interface {
-	Get(ctx context.Context, desc Description, w io.Writer) error
-	Head(ctx context.Context, desc Description) (int, error)
+	// Get opens the artifact with its metadata.
+	// The caller must close the returned reader.
+	// If the reader implements `io.Seeker`, range requests are served from it.
+	Get(ctx context.Context, desc Description) (io.ReadCloser, Info, error)
+	Head(ctx context.Context, desc Description) (Info, error)
 	Put(ctx context.Context, desc Description, r io.Reader) error
+	Delete(ctx context.Context, desc Description) error
 
 	Close() error
 }
+
+// ReaderAt is implemented by stores that can read a part of an artifact
+// cheaper than `Store.Get`, e.g. without verifying or downloading the whole artifact.
+type ReaderAt interface {
+	// ReadAt reads len(p) bytes of the artifact at offset `off` as `io.ReaderAt` does
+	// so it returns `io.EOF` with fewer bytes if the artifact ends before.
+	ReadAt(ctx context.Context, desc Description, p []byte, off int64) (int, error)
+}
+
+type Entry struct {
+	Description
+	Size    int64
+	ModTime time.Time
+
+	// Hex encoded SHA-256 digest of the artifact and the name of the client who uploaded it.
+	// They are empty if the store does not record them.
+	Digest   string
+	Uploader string
+}
+
+// Lister is implemented by stores that can enumerate their entries.
+type Lister interface {
+	// List calls `fn` for each entry in the store.
+	// Listing stops if `fn` returns an error and the error is returned.
+	List(ctx context.Context, fn func(entry Entry) error) error
+}
@@ -17,6 +17,17 @@ var DescriptionFoo = main.Description{
 	Hash:    "baz",
 }
 
+// readAll reads the whole artifact from the store.
+func readAll(ctx context.Context, store main.Store, desc main.Description) ([]byte, error) {
+	r, _, err := store.Get(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
 type StoreSetup interface {
 	New(t *testing.T) (main.Store, error)
 }
@@ -43,29 +54,79 @@ func (s *StoreTestSuite) TestAll() {
 	_, err := s.store.Head(ctx, DescriptionFoo)
 	s.require.ErrorIs(err, main.ErrNotExist)
 
-	err = s.store.Get(ctx, DescriptionFoo, io.Discard)
+	_, _, err = s.store.Get(ctx, DescriptionFoo)
 	s.require.ErrorIs(err, main.ErrNotExist)
 
 	data := randomData(s.T())
 	err = s.store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
 	s.require.NoError(err)
 
-	size, err := s.store.Head(ctx, DescriptionFoo)
+	info, err := s.store.Head(ctx, DescriptionFoo)
+	s.require.NoError(err)
+	s.require.Equal(int64(len(data)), info.Size)
+
+	r, info, err := s.store.Get(ctx, DescriptionFoo)
 	s.require.NoError(err)
-	s.require.Equal(len(data), size)
+	defer r.Close()
+	s.require.Equal(int64(len(data)), info.Size)
 
-	var received bytes.Buffer
-	err = s.store.Get(ctx, DescriptionFoo, &received)
+	received, err := io.ReadAll(r)
 	s.require.NoError(err)
-	s.require.Equal(data, received.Bytes())
+	s.require.Equal(data, received)
 }
 
 func (s *StoreTestSuite) TestGetNotExist() {
 	ctx := context.Background()
 
-	var received bytes.Buffer
-	err := s.store.Get(ctx, DescriptionFoo, &received)
+	_, _, err := s.store.Get(ctx, DescriptionFoo)
+	s.require.ErrorIs(err, main.ErrNotExist)
+}
+
+func (s *StoreTestSuite) TestSeekable() {
+	ctx := context.Background()
+
+	err := s.store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(s.T())))
+	s.require.NoError(err)
+
+	r, info, err := s.store.Get(ctx, DescriptionFoo)
+	s.require.NoError(err)
+	defer r.Close()
+
+	_, ok := r.(io.Seeker)
+	s.require.Equal(ok, info.Seekable)
+
+	info, err = s.store.Head(ctx, DescriptionFoo)
+	s.require.NoError(err)
+	s.require.Equal(ok, info.Seekable)
+}
+
+func (s *StoreTestSuite) TestReadAt() {
+	ra, ok := s.store.(main.ReaderAt)
+	if !ok {
+		s.T().Skip("store cannot read a part of the artifact")
+	}
+
+	ctx := context.Background()
+
+	_, err := ra.ReadAt(ctx, DescriptionFoo, make([]byte, 16), 0)
 	s.require.ErrorIs(err, main.ErrNotExist)
+
+	data := randomData(s.T())
+	err = s.store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+	s.require.NoError(err)
+
+	b := make([]byte, 16)
+	n, err := ra.ReadAt(ctx, DescriptionFoo, b, 0)
+	s.require.NoError(err)
+	s.require.Equal(data[:16], b[:n])
+
+	n, err = ra.ReadAt(ctx, DescriptionFoo, b, int64(len(data)-8))
+	s.require.ErrorIs(err, io.EOF)
+	s.require.Equal(data[len(data)-8:], b[:n])
+
+	n, err = ra.ReadAt(ctx, DescriptionFoo, b, int64(len(data)+8))
+	s.require.ErrorIs(err, io.EOF)
+	s.require.Zero(n)
 }
 
 func (s *StoreTestSuite) TestPutAlreadyExist() {
@@ -77,3 +138,22 @@ func (s *StoreTestSuite) TestPutAlreadyExist() {
 	err = s.store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(s.T())))
 	s.require.ErrorIs(err, main.ErrExist)
 }
+
+func (s *StoreTestSuite) TestDelete() {
+	ctx := context.Background()
+
+	err := s.store.Delete(ctx, DescriptionFoo)
+	s.require.ErrorIs(err, main.ErrNotExist)
+
+	err = s.store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(s.T())))
+	s.require.NoError(err)
+
+	err = s.store.Delete(ctx, DescriptionFoo)
+	s.require.NoError(err)
+
+	_, err = s.store.Head(ctx, DescriptionFoo)
+	s.require.ErrorIs(err, main.ErrNotExist)
+
+	err = s.store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(s.T())))
+	s.require.NoError(err)
+}
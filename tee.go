@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/rs/zerolog"
+)
+
+// fanout writes to multiple readers consumed by `put`s running concurrently.
+// A reader that stops consuming, e.g. `Put` returned `ErrExist`, does not stop writes to the others.
+type fanout struct {
+	pipes   []*io.PipeWriter
+	broken  []bool
+	results []chan error
+}
+
+func newFanout(n int, put func(i int, r io.Reader) error) *fanout {
+	f := &fanout{
+		pipes:   make([]*io.PipeWriter, n),
+		broken:  make([]bool, n),
+		results: make([]chan error, n),
+	}
+	for i := 0; i < n; i++ {
+		r, w := io.Pipe()
+		f.pipes[i] = w
+		f.results[i] = make(chan error, 1)
+
+		go func(i int) {
+			err := put(i, r)
+
+			// Closing the reader makes further writes fail so the writer stops to write to it.
+			r.Close()
+			f.results[i] <- err
+		}(i)
+	}
+
+	return f
+}
+
+func (f *fanout) Write(b []byte) (int, error) {
+	alive := false
+	for i, p := range f.pipes {
+		if f.broken[i] {
+			continue
+		}
+		if _, err := p.Write(b); err != nil {
+			f.broken[i] = true
+			continue
+		}
+
+		alive = true
+	}
+	if !alive && len(f.pipes) > 0 {
+		return 0, errors.New("no reader consumes")
+	}
+
+	return len(b), nil
+}
+
+// Close closes the readers with `err`, or with EOF if `err` is nil, and returns results of the `put`s.
+func (f *fanout) Close(err error) []error {
+	errs := make([]error, len(f.pipes))
+	for i, p := range f.pipes {
+		p.CloseWithError(err)
+		errs[i] = <-f.results[i]
+	}
+
+	return errs
+}
+
+// persistingReader reads from `body` and persists what is read to the stores.
+// The artifact is persisted only if it is read to the end.
+type persistingReader struct {
+	ctx  context.Context
+	body io.ReadCloser
+	f    *fanout
+	eof  bool
+}
+
+func newPersistingReader(ctx context.Context, body io.ReadCloser, desc Description, stores ...Store) *persistingReader {
+	return &persistingReader{
+		ctx:  ctx,
+		body: body,
+		f: newFanout(len(stores), func(i int, r io.Reader) error {
+			return stores[i].Put(ctx, desc, r)
+		}),
+	}
+}
+
+func (r *persistingReader) Read(b []byte) (int, error) {
+	n, err := r.body.Read(b)
+	if n > 0 {
+		// Failure of the stores does not stop reads.
+		r.f.Write(b[:n])
+	}
+	if err == io.EOF {
+		r.eof = true
+	}
+
+	return n, err
+}
+
+// Close closes the body and waits for the stores to be written.
+func (r *persistingReader) Close() error {
+	err := r.body.Close()
+
+	var err_incomplete error
+	if !r.eof {
+		err_incomplete = errors.New("artifact is not read to the end")
+	}
+
+	for _, err := range r.f.Close(err_incomplete) {
+		// Failures are expected if the artifact is not read to the end
+		// and stores such as memory tier do not hold artifacts larger than their limits.
+		if err == nil || errors.Is(err, ErrExist) || errors.Is(err, ErrTooLarge) || !r.eof {
+			continue
+		}
+
+		zerolog.Ctx(r.ctx).Warn().Err(err).Msg("failed to persist the artifact")
+	}
+
+	return err
+}
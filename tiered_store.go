@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/rs/zerolog"
+)
+
+// tieredStore composes stores in order of preference, e.g. memory, local disk and then remote.
+// Artifacts are read from the first tier that has them and promoted into the preceding tiers.
+// Uploads are written through to all tiers or to the tiers given by `WithWriteTiers`.
+type tieredStore struct {
+	tiers []Store
+	write []int
+}
+
+type tieredOption func(s *tieredStore)
+
+// WithWriteTiers sets indices of the tiers where uploads are written to.
+func WithWriteTiers(indices ...int) tieredOption {
+	return func(s *tieredStore) {
+		s.write = append([]int{}, indices...)
+	}
+}
+
+func NewTieredStore(tiers []Store, opts ...tieredOption) (*tieredStore, error) {
+	s := &tieredStore{tiers: tiers}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if len(s.tiers) == 0 {
+		return nil, errors.New("at least one tier must be given")
+	}
+	if s.write == nil {
+		s.write = make([]int, len(s.tiers))
+		for i := range s.tiers {
+			s.write[i] = i
+		}
+	}
+	if len(s.write) == 0 {
+		return nil, errors.New("at least one tier must be written")
+	}
+
+	seen := map[int]bool{}
+	for _, i := range s.write {
+		if i < 0 || i >= len(s.tiers) {
+			return nil, fmt.Errorf("tier %d does not exist", i)
+		}
+		if seen[i] {
+			return nil, fmt.Errorf("tier %d is given more than once", i)
+		}
+		seen[i] = true
+	}
+
+	return s, nil
+}
+
+// Get reads the artifact from the first tier that has it.
+// Failure of a tier is logged and the next tier is tried.
+func (s *tieredStore) Get(ctx context.Context, desc Description) (io.ReadCloser, Info, error) {
+	var err_first error
+	for i, tier := range s.tiers {
+		r, info, err := tier.Get(ctx, desc)
+		if err == nil {
+			if i == 0 {
+				return r, info, nil
+			}
+
+			// Promoted while it is read so it cannot be sought.
+			info.Seekable = false
+			return newPersistingReader(ctx, r, desc, s.tiers[:i]...), info, nil
+		}
+		if errors.Is(err, ErrNotExist) {
+			continue
+		}
+
+		zerolog.Ctx(ctx).Warn().Err(err).Int("tier", i).Msg("failed to get from the tier")
+		if err_first == nil {
+			err_first = fmt.Errorf("tier %d: %w", i, err)
+		}
+	}
+	if err_first != nil {
+		return nil, Info{}, err_first
+	}
+
+	return nil, Info{}, ErrNotExist
+}
+
+func (s *tieredStore) Head(ctx context.Context, desc Description) (Info, error) {
+	var err_first error
+	for i, tier := range s.tiers {
+		info, err := tier.Head(ctx, desc)
+		if err == nil {
+			// Get from the lower tiers promotes the artifact while it is read.
+			if i > 0 {
+				info.Seekable = false
+			}
+			return info, nil
+		}
+		if errors.Is(err, ErrNotExist) {
+			continue
+		}
+
+		zerolog.Ctx(ctx).Warn().Err(err).Int("tier", i).Msg("failed to head from the tier")
+		if err_first == nil {
+			err_first = fmt.Errorf("tier %d: %w", i, err)
+		}
+	}
+	if err_first != nil {
+		return Info{}, err_first
+	}
+
+	return Info{}, ErrNotExist
+}
+
+// Put writes the artifact to the write tiers concurrently.
+// It succeeds if any write tier stores the artifact; failures of the other tiers are logged
+// and tiers that cannot hold it, e.g. memory tier for an artifact larger than its limit, are skipped.
+// It returns `ErrExist` if none of them stores it but some already have it.
+func (s *tieredStore) Put(ctx context.Context, desc Description, r io.Reader) error {
+	f := newFanout(len(s.write), func(i int, r io.Reader) error {
+		return s.tiers[s.write[i]].Put(ctx, desc, r)
+	})
+
+	_, err_copy := io.Copy(f, r)
+	errs := f.Close(err_copy)
+
+	stored := 0
+	exists := 0
+	var err_first error
+	var err_too_large error
+	for i, err := range errs {
+		if err == nil {
+			stored++
+			continue
+		}
+		if errors.Is(err, ErrExist) {
+			exists++
+			continue
+		}
+		if errors.Is(err, ErrTooLarge) {
+			zerolog.Ctx(ctx).Debug().Err(err).Int("tier", s.write[i]).Msg("artifact is skipped by the tier")
+			if err_too_large == nil {
+				err_too_large = fmt.Errorf("tier %d: %w", s.write[i], err)
+			}
+			continue
+		}
+
+		zerolog.Ctx(ctx).Warn().Err(err).Int("tier", s.write[i]).Msg("failed to put to the tier")
+		if err_first == nil {
+			err_first = fmt.Errorf("tier %d: %w", s.write[i], err)
+		}
+	}
+	if stored > 0 {
+		return nil
+	}
+	if exists > 0 {
+		return ErrExist
+	}
+	if err_first != nil {
+		return err_first
+	}
+
+	// No tier can hold it.
+	return err_too_large
+}
+
+// Delete deletes the artifact from all tiers.
+func (s *tieredStore) Delete(ctx context.Context, desc Description) error {
+	deleted := false
+	for i, tier := range s.tiers {
+		err := tier.Delete(ctx, desc)
+		if err == nil {
+			deleted = true
+			continue
+		}
+		if errors.Is(err, ErrNotExist) {
+			continue
+		}
+
+		return fmt.Errorf("tier %d: %w", i, err)
+	}
+	if !deleted {
+		return ErrNotExist
+	}
+
+	return nil
+}
+
+// List lists the entries in all tiers that can enumerate their entries.
+// An entry in multiple tiers is listed once as it is in the first tier.
+func (s *tieredStore) List(ctx context.Context, fn func(entry Entry) error) error {
+	listed := false
+	seen := map[Description]bool{}
+	for i, tier := range s.tiers {
+		lister, ok := tier.(Lister)
+		if !ok {
+			continue
+		}
+
+		listed = true
+		err := lister.List(ctx, func(entry Entry) error {
+			if seen[entry.Description] {
+				return nil
+			}
+
+			seen[entry.Description] = true
+			return fn(entry)
+		})
+		if err != nil {
+			return fmt.Errorf("tier %d: %w", i, err)
+		}
+	}
+	if !listed {
+		return errors.New("no tier can enumerate its entries")
+	}
+
+	return nil
+}
+
+func (s *tieredStore) Close() error {
+	errs := []error{}
+	for i, tier := range s.tiers {
+		if err := tier.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("tier %d: %w", i, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
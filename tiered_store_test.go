@@ -0,0 +1,290 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	main "github.com/lesomnus/vcpkg-cache-http"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type TieredStoreSetup struct{}
+
+func (s *TieredStoreSetup) New(t *testing.T) (main.Store, error) {
+	hot, err := NewTestMemoryStore(t)
+	if err != nil {
+		return nil, err
+	}
+
+	cold, err := NewTestFsStore(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return main.NewTieredStore([]main.Store{hot, cold})
+}
+
+func TestTieredStoreSuite(t *testing.T) {
+	suite.Run(t, &StoreTestSuite{Store: &TieredStoreSetup{}})
+}
+
+func TestTieredStore(t *testing.T) {
+	WithTiers := func(f func(t *testing.T, tiers []main.Store)) func(t *testing.T) {
+		return func(t *testing.T) {
+			tiers := []main.Store{}
+			for i := 0; i < 3; i++ {
+				tier, err := NewTestFsStore(t)
+				require.NoError(t, err)
+
+				tiers = append(tiers, tier)
+			}
+
+			f(t, tiers)
+		}
+	}
+
+	t.Run("artifact is promoted into faster tiers", WithTiers(func(t *testing.T, tiers []main.Store) {
+		require := require.New(t)
+
+		store, err := main.NewTieredStore(tiers)
+		require.NoError(err)
+
+		ctx := context.Background()
+		data := randomData(t)
+		err = tiers[2].Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		received, err := readAll(ctx, store, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(data, received)
+
+		for _, tier := range tiers {
+			received, err := readAll(ctx, tier, DescriptionFoo)
+			require.NoError(err)
+			require.Equal(data, received)
+		}
+	}))
+
+	t.Run("artifact too large for the faster tier is not promoted", func(t *testing.T) {
+		require := require.New(t)
+
+		hot, err := main.NewMemoryStore(main.WithMemoryMaxSize(100))
+		require.NoError(err)
+
+		cold, err := NewTestFsStore(t)
+		require.NoError(err)
+
+		store, err := main.NewTieredStore([]main.Store{hot, cold})
+		require.NoError(err)
+
+		ctx := context.Background()
+		data := randomData(t)
+		err = cold.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		// It is not a failure to be warned.
+		logs := &bytes.Buffer{}
+		received, err := readAll(zerolog.New(logs).WithContext(ctx), store, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(data, received)
+		require.Empty(logs.String())
+
+		_, err = hot.Head(ctx, DescriptionFoo)
+		require.ErrorIs(err, main.ErrNotExist)
+	})
+
+	t.Run("artifact is not promoted if it is not read to the end", WithTiers(func(t *testing.T, tiers []main.Store) {
+		require := require.New(t)
+
+		store, err := main.NewTieredStore(tiers)
+		require.NoError(err)
+
+		ctx := context.Background()
+		err = tiers[1].Put(ctx, DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		r, _, err := store.Get(ctx, DescriptionFoo)
+		require.NoError(err)
+		_, err = r.Read(make([]byte, 10))
+		require.NoError(err)
+		err = r.Close()
+		require.NoError(err)
+
+		_, err = tiers[0].Head(ctx, DescriptionFoo)
+		require.ErrorIs(err, main.ErrNotExist)
+	}))
+
+	t.Run("upload is written to the write tiers", WithTiers(func(t *testing.T, tiers []main.Store) {
+		require := require.New(t)
+
+		store, err := main.NewTieredStore(tiers, main.WithWriteTiers(0, 2))
+		require.NoError(err)
+
+		ctx := context.Background()
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		_, err = tiers[0].Head(ctx, DescriptionFoo)
+		require.NoError(err)
+		_, err = tiers[1].Head(ctx, DescriptionFoo)
+		require.ErrorIs(err, main.ErrNotExist)
+		_, err = tiers[2].Head(ctx, DescriptionFoo)
+		require.NoError(err)
+	}))
+
+	t.Run("upload is written to the tiers not having it", WithTiers(func(t *testing.T, tiers []main.Store) {
+		require := require.New(t)
+
+		store, err := main.NewTieredStore(tiers)
+		require.NoError(err)
+
+		ctx := context.Background()
+		data := randomData(t)
+		err = tiers[1].Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		for _, tier := range tiers {
+			_, err := tier.Head(ctx, DescriptionFoo)
+			require.NoError(err)
+		}
+
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.ErrorIs(err, main.ErrExist)
+	}))
+
+	t.Run("tier that cannot hold the artifact is skipped", func(t *testing.T) {
+		require := require.New(t)
+
+		hot, err := main.NewMemoryStore(main.WithMemoryMaxSize(100))
+		require.NoError(err)
+
+		cold, err := NewTestFsStore(t)
+		require.NoError(err)
+
+		store, err := main.NewTieredStore([]main.Store{hot, cold})
+		require.NoError(err)
+
+		ctx := context.Background()
+		data := randomData(t)
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		_, err = hot.Head(ctx, DescriptionFoo)
+		require.ErrorIs(err, main.ErrNotExist)
+
+		received, err := readAll(ctx, cold, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(data, received)
+
+		// Fails if no tier can hold it.
+		store, err = main.NewTieredStore([]main.Store{hot, cold}, main.WithWriteTiers(0))
+		require.NoError(err)
+
+		desc := main.Description{Name: "foo", Version: "bar", Hash: "qux"}
+		err = store.Put(ctx, desc, bytes.NewReader(data))
+		require.ErrorIs(err, main.ErrTooLarge)
+	})
+
+	t.Run("upload succeeds if any tier stores it", WithTiers(func(t *testing.T, tiers []main.Store) {
+		require := require.New(t)
+
+		store, err := main.NewTieredStore([]main.Store{tiers[0], &brokenStore{tiers[1]}})
+		require.NoError(err)
+
+		ctx := context.Background()
+		data := randomData(t)
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		received, err := readAll(ctx, tiers[0], DescriptionFoo)
+		require.NoError(err)
+		require.Equal(data, received)
+
+		// Fails if no tier stores it.
+		store, err = main.NewTieredStore([]main.Store{tiers[0], &brokenStore{tiers[1]}}, main.WithWriteTiers(1))
+		require.NoError(err)
+
+		desc := main.Description{Name: "foo", Version: "bar", Hash: "qux"}
+		err = store.Put(ctx, desc, bytes.NewReader(data))
+		require.ErrorContains(err, "broken")
+	}))
+
+	t.Run("next tier is read if a tier fails", WithTiers(func(t *testing.T, tiers []main.Store) {
+		require := require.New(t)
+
+		ctx := context.Background()
+		data := randomData(t)
+		err := tiers[1].Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		store, err := main.NewTieredStore([]main.Store{&brokenStore{tiers[0]}, tiers[1]})
+		require.NoError(err)
+
+		received, err := readAll(ctx, store, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(data, received)
+
+		store, err = main.NewTieredStore([]main.Store{&brokenStore{tiers[0]}, tiers[2]})
+		require.NoError(err)
+
+		_, _, err = store.Get(ctx, DescriptionFoo)
+		require.ErrorContains(err, "broken")
+		require.NotErrorIs(err, main.ErrNotExist)
+	}))
+
+	t.Run("entries in multiple tiers are listed once", WithTiers(func(t *testing.T, tiers []main.Store) {
+		require := require.New(t)
+
+		store, err := main.NewTieredStore(tiers)
+		require.NoError(err)
+
+		ctx := context.Background()
+		descs := []main.Description{
+			{Name: "foo", Version: "bar", Hash: "a"},
+			{Name: "foo", Version: "bar", Hash: "b"},
+		}
+		err = tiers[0].Put(ctx, descs[0], bytes.NewReader(randomData(t)))
+		require.NoError(err)
+		err = tiers[2].Put(ctx, descs[0], bytes.NewReader(randomData(t)))
+		require.NoError(err)
+		err = tiers[2].Put(ctx, descs[1], bytes.NewReader(randomData(t)))
+		require.NoError(err)
+
+		listed := []main.Description{}
+		err = store.List(ctx, func(entry main.Entry) error {
+			listed = append(listed, entry.Description)
+			return nil
+		})
+		require.NoError(err)
+		require.ElementsMatch(descs, listed)
+
+		err = store.Delete(ctx, descs[0])
+		require.NoError(err)
+		for _, tier := range tiers {
+			_, err := tier.Head(ctx, descs[0])
+			require.ErrorIs(err, main.ErrNotExist)
+		}
+	}))
+
+	t.Run("invalid write tiers", func(t *testing.T) {
+		require := require.New(t)
+
+		tier, err := NewTestFsStore(t)
+		require.NoError(err)
+
+		_, err = main.NewTieredStore(nil)
+		require.ErrorContains(err, "at least one tier")
+		_, err = main.NewTieredStore([]main.Store{tier}, main.WithWriteTiers())
+		require.ErrorContains(err, "at least one tier")
+		_, err = main.NewTieredStore([]main.Store{tier}, main.WithWriteTiers(1))
+		require.ErrorContains(err, "not exist")
+		_, err = main.NewTieredStore([]main.Store{tier}, main.WithWriteTiers(0, 0))
+		require.ErrorContains(err, "more than once")
+	})
+}
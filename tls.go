@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// NewTlsConfig creates TLS config for the server.
+// It returns nil if TLS is not configured.
+func NewTlsConfig(conf *AppConfig) (*tls.Config, error) {
+	if conf.Auth != nil && len(conf.Auth.CertRoles) > 0 && conf.TlsClientCa == "" {
+		return nil, errors.New("roles for certificates are given but client CA is not")
+	}
+	if conf.TlsCert == "" && conf.TlsKey == "" {
+		if conf.TlsClientCa != "" {
+			return nil, errors.New("client CA is given but server certificate is not")
+		}
+		return nil, nil
+	}
+	if conf.TlsCert == "" || conf.TlsKey == "" {
+		return nil, errors.New("both certificate and key must be given")
+	}
+
+	cert, err := tls.LoadX509KeyPair(conf.TlsCert, conf.TlsKey)
+	if err != nil {
+		return nil, fmt.Errorf("load key pair: %w", err)
+	}
+
+	tls_conf := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if conf.TlsClientCa == "" {
+		return tls_conf, nil
+	}
+
+	data, err := os.ReadFile(conf.TlsClientCa)
+	if err != nil {
+		return nil, fmt.Errorf("read client CA: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificate found in client CA")
+	}
+
+	tls_conf.ClientCAs = pool
+
+	// If roles are given for the certificates, clients without certificate
+	// can still be authenticated by other methods.
+	// Otherwise, mutual TLS is the only gate.
+	if conf.Auth != nil && len(conf.Auth.CertRoles) > 0 {
+		tls_conf.ClientAuth = tls.VerifyClientCertIfGiven
+	} else {
+		tls_conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tls_conf, nil
+}
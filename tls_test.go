@@ -0,0 +1,286 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	main "github.com/lesomnus/vcpkg-cache-http"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+
+	CertPath string
+	KeyPath  string
+}
+
+func (c *testCert) TlsCertificate() tls.Certificate {
+	return tls.Certificate{
+		Certificate: [][]byte{c.cert.Raw},
+		PrivateKey:  c.key,
+	}
+}
+
+// newTestCert creates a certificate signed by `parent` or a self-signed CA certificate if `parent` is nil.
+func newTestCert(t *testing.T, subject pkix.Name, parent *testCert) *testCert {
+	require := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer_cert := tmpl
+	signer_key := key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer_cert = parent.cert
+		signer_key = parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer_cert, &key.PublicKey, signer_key)
+	require.NoError(err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(err)
+
+	key_der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(err)
+
+	dir := t.TempDir()
+	c := &testCert{
+		cert: cert,
+		key:  key,
+
+		CertPath: filepath.Join(dir, "cert.pem"),
+		KeyPath:  filepath.Join(dir, "key.pem"),
+	}
+
+	err = os.WriteFile(c.CertPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	require.NoError(err)
+	err = os.WriteFile(c.KeyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key_der}), 0600)
+	require.NoError(err)
+
+	return c
+}
+
+func TestNewTlsConfig(t *testing.T) {
+	t.Run("nil if not configured", func(t *testing.T) {
+		require := require.New(t)
+
+		conf, err := main.NewTlsConfig(&main.AppConfig{})
+		require.NoError(err)
+		require.Nil(conf)
+	})
+
+	t.Run("both certificate and key must be given", func(t *testing.T) {
+		require := require.New(t)
+
+		ca := newTestCert(t, pkix.Name{CommonName: "ca"}, nil)
+
+		_, err := main.NewTlsConfig(&main.AppConfig{TlsCert: ca.CertPath})
+		require.ErrorContains(err, "both")
+
+		_, err = main.NewTlsConfig(&main.AppConfig{TlsKey: ca.KeyPath})
+		require.ErrorContains(err, "both")
+
+		_, err = main.NewTlsConfig(&main.AppConfig{TlsClientCa: ca.CertPath})
+		require.ErrorContains(err, "client CA")
+	})
+
+	t.Run("fail if client CA is invalid", func(t *testing.T) {
+		require := require.New(t)
+
+		ca := newTestCert(t, pkix.Name{CommonName: "ca"}, nil)
+		server := newTestCert(t, pkix.Name{CommonName: "server"}, ca)
+
+		_, err := main.NewTlsConfig(&main.AppConfig{
+			TlsCert:     server.CertPath,
+			TlsKey:      server.KeyPath,
+			TlsClientCa: server.KeyPath,
+		})
+		require.ErrorContains(err, "no certificate")
+	})
+
+	t.Run("fail if roles for certificates are given without client CA", func(t *testing.T) {
+		require := require.New(t)
+
+		ca := newTestCert(t, pkix.Name{CommonName: "ca"}, nil)
+		server := newTestCert(t, pkix.Name{CommonName: "server"}, ca)
+
+		_, err := main.NewTlsConfig(&main.AppConfig{
+			TlsCert: server.CertPath,
+			TlsKey:  server.KeyPath,
+			Auth: &main.AuthConfig{
+				CertRoles: map[string]string{"ci": "rw"},
+			},
+		})
+		require.ErrorContains(err, "client CA")
+
+		_, err = main.NewTlsConfig(&main.AppConfig{
+			Auth: &main.AuthConfig{
+				CertRoles: map[string]string{"ci": "rw"},
+			},
+		})
+		require.ErrorContains(err, "client CA")
+	})
+
+	t.Run("client certificate is required if no roles for certificates", func(t *testing.T) {
+		require := require.New(t)
+
+		ca := newTestCert(t, pkix.Name{CommonName: "ca"}, nil)
+		server := newTestCert(t, pkix.Name{CommonName: "server"}, ca)
+
+		conf, err := main.NewTlsConfig(&main.AppConfig{
+			TlsCert:     server.CertPath,
+			TlsKey:      server.KeyPath,
+			TlsClientCa: ca.CertPath,
+		})
+		require.NoError(err)
+		require.Equal(tls.RequireAndVerifyClientCert, conf.ClientAuth)
+
+		conf, err = main.NewTlsConfig(&main.AppConfig{
+			TlsCert:     server.CertPath,
+			TlsKey:      server.KeyPath,
+			TlsClientCa: ca.CertPath,
+			Auth: &main.AuthConfig{
+				CertRoles: map[string]string{"ci": "rw"},
+			},
+		})
+		require.NoError(err)
+		require.Equal(tls.VerifyClientCertIfGiven, conf.ClientAuth)
+	})
+}
+
+func TestServerMutualTls(t *testing.T) {
+	require := require.New(t)
+
+	ca := newTestCert(t, pkix.Name{CommonName: "ca"}, nil)
+	server_cert := newTestCert(t, pkix.Name{CommonName: "server"}, ca)
+	ci := newTestCert(t, pkix.Name{CommonName: "ci", Organization: []string{"Example"}}, ca)
+	dev := newTestCert(t, pkix.Name{CommonName: "dev", Organization: []string{"Example"}}, ca)
+	other := newTestCert(t, pkix.Name{CommonName: "other"}, ca)
+	stranger := newTestCert(t, pkix.Name{CommonName: "ci"}, newTestCert(t, pkix.Name{CommonName: "evil"}, nil))
+
+	conf := &main.AppConfig{
+		TlsCert:     server_cert.CertPath,
+		TlsKey:      server_cert.KeyPath,
+		TlsClientCa: ca.CertPath,
+		Auth: &main.AuthConfig{
+			CertRoles: map[string]string{
+				"ci":               "read-write",
+				"CN=dev,O=Example": "read",
+			},
+		},
+	}
+
+	tls_conf, err := main.NewTlsConfig(conf)
+	require.NoError(err)
+
+	auth, err := main.NewAuthenticator(conf.Auth)
+	require.NoError(err)
+
+	store, err := NewTestFsStore(t)
+	require.NoError(err)
+
+	err = store.Put(context.Background(), DescriptionFoo, bytes.NewReader(randomData(t)))
+	require.NoError(err)
+
+	server := httptest.NewUnstartedServer(&main.Handler{
+		Store: store,
+		Log:   zerolog.New(io.Discard),
+		Auth:  auth,
+
+		IsReadable: true,
+		IsWritable: true,
+	})
+	server.TLS = tls_conf
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	request := func(cert *testCert, method string, desc main.Description) (*http.Response, error) {
+		tls_conf := &tls.Config{RootCAs: roots}
+		if cert != nil {
+			tls_conf.Certificates = []tls.Certificate{cert.TlsCertificate()}
+		}
+
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tls_conf}}
+		req, err := http.NewRequest(method, server.URL+desc.String(), bytes.NewReader([]byte("foo")))
+		require.NoError(err)
+
+		res, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		res.Body.Close()
+		return res, nil
+	}
+
+	desc := main.Description{Name: "foo", Version: "bar", Hash: "qux"}
+	test_cases := []struct {
+		cert   *testCert
+		method string
+		status int
+	}{
+		{cert: ci, method: http.MethodGet, status: http.StatusOK},
+		{cert: ci, method: http.MethodPut, status: http.StatusOK},
+		{cert: dev, method: http.MethodGet, status: http.StatusOK},
+		{cert: dev, method: http.MethodPut, status: http.StatusForbidden},
+		// Certificate not listed is left to other credentials.
+		{cert: other, method: http.MethodGet, status: http.StatusUnauthorized},
+		{cert: nil, method: http.MethodGet, status: http.StatusUnauthorized},
+	}
+	for i, tc := range test_cases {
+		d := DescriptionFoo
+		if tc.method == http.MethodPut {
+			d = desc
+			d.Hash = d.Hash + string(rune('a'+i))
+		}
+
+		res, err := request(tc.cert, tc.method, d)
+		require.NoError(err, i)
+		require.Equal(tc.status, res.StatusCode, i)
+	}
+
+	// Certificate not issued by the client CA is not sent by the client.
+	res, err := request(stranger, http.MethodGet, DescriptionFoo)
+	require.NoError(err)
+	require.Equal(http.StatusUnauthorized, res.StatusCode)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// URL templates locate artifacts on upstream servers, e.g. for `proxyStore` and `httpStore`,
+// by `{name}`, `{version}` and `{sha}` placeholders.
+
+// expandUrlTemplate returns the URL template with `{name}`, `{version}` and `{sha}` placeholders.
+// If there is no placeholder, the placeholders are appended to the given base URL.
+func expandUrlTemplate(u string) string {
+	if strings.Contains(u, "{") {
+		return u
+	}
+
+	return strings.TrimSuffix(u, "/") + "/{name}/{version}/{sha}"
+}
+
+// resolveUrlTemplate replaces the placeholders with the escaped fields of `desc`
+// so the fields containing characters such as `#` or `?` are not taken as a part of the URL other than the path.
+func resolveUrlTemplate(tmpl string, desc Description) string {
+	return strings.NewReplacer(
+		"{name}", url.PathEscape(desc.Name),
+		"{version}", url.PathEscape(desc.Version),
+		"{sha}", url.PathEscape(desc.Hash),
+	).Replace(tmpl)
+}
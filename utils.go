@@ -2,7 +2,12 @@ package main
 
 import (
 	"crypto/rand"
+	"errors"
+	"fmt"
 	"math/big"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func getRandomString(n int) string {
@@ -22,3 +27,62 @@ func getRandomString(n int) string {
 func getTicket() string {
 	return getRandomString(12)
 }
+
+// parseSize parses a size in bytes such as "512", "100M", "10GB" or "10GiB".
+// Units with "i" are power of 1024 and the others are power of 1000.
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		scale  int64
+	}{
+		{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+		{"K", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+		{"k", 1e3},
+	}
+
+	v := strings.TrimSuffix(strings.TrimSpace(s), "B")
+	scale := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(v, unit.suffix) {
+			v = strings.TrimSuffix(v, unit.suffix)
+			scale = unit.scale
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if n < 0 {
+		return 0, errors.New("size cannot be negative")
+	}
+
+	return int64(n * float64(scale)), nil
+}
+
+// parseDuration parses a duration as `time.ParseDuration` does
+// but also accepts days such as "14d" or "1d12h".
+func parseDuration(s string) (time.Duration, error) {
+	days, rest, ok := strings.Cut(s, "d")
+	if !ok {
+		return time.ParseDuration(s)
+	}
+
+	n, err := strconv.ParseUint(days, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+
+	d := time.Duration(n) * 24 * time.Hour
+	if rest == "" {
+		return d, nil
+	}
+
+	r, err := time.ParseDuration(rest)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+
+	return d + r, nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressed artifacts start with a zstd skippable frame holding the original size
+// since the frame header of zstd does not always have the content size, e.g. for small contents.
+// The frame is 16 bytes of magic number, size of the frame data which is 8, and the original size in little endian.
+var zstdSizeFrameMagic = []byte{0x50, 0x2a, 0x4d, 0x18}
+
+const zstdSizeFrameLen = 16
+
+func zstdSizeFrame(size int64) []byte {
+	b := make([]byte, zstdSizeFrameLen)
+	copy(b, zstdSizeFrameMagic)
+	binary.LittleEndian.PutUint32(b[4:], 8)
+	binary.LittleEndian.PutUint64(b[8:], uint64(size))
+	return b
+}
+
+// zstdParseSizeFrame returns the original size if `b` starts with the size frame.
+func zstdParseSizeFrame(b []byte) (int64, bool) {
+	if len(b) < zstdSizeFrameLen || !bytes.HasPrefix(b, zstdSizeFrameMagic) || binary.LittleEndian.Uint32(b[4:]) != 8 {
+		return 0, false
+	}
+
+	return int64(binary.LittleEndian.Uint64(b[8:])), true
+}
+
+// zstdStore compresses artifacts with zstd before storing them to the underlying store.
+// Artifacts stored without compression, e.g. before the compression is enabled, are served as they are.
+type zstdStore struct {
+	store Store
+	level zstd.EncoderLevel
+
+	// Directory where uploads are spooled; the temporary directory of the system if it is empty.
+	spool_dir string
+}
+
+type zstdOption func(s *zstdStore)
+
+func WithZstdLevel(level zstd.EncoderLevel) zstdOption {
+	return func(s *zstdStore) {
+		s.level = level
+	}
+}
+
+// WithZstdSpoolDir sets the directory where uploads are spooled to find their sizes.
+func WithZstdSpoolDir(p string) zstdOption {
+	return func(s *zstdStore) {
+		s.spool_dir = p
+	}
+}
+
+func NewZstdStore(store Store, opts ...zstdOption) *zstdStore {
+	s := &zstdStore{store: store, level: zstd.SpeedDefault}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// zstdReadCloser decompresses the artifact and closes the underlying reader on close.
+type zstdReadCloser struct {
+	*zstd.Decoder
+	c io.Closer
+}
+
+func (r *zstdReadCloser) Close() error {
+	r.Decoder.Close()
+	return r.c.Close()
+}
+
+// open reads the beginning of the artifact to tell if it is compressed.
+// Returned reader reads compressed frames after the size frame if it is compressed,
+// otherwise it reads from the beginning of the artifact.
+func (s *zstdStore) open(ctx context.Context, desc Description) (io.ReadCloser, Info, bool, error) {
+	r, info, err := s.store.Get(ctx, desc)
+	if err != nil {
+		return nil, Info{}, false, err
+	}
+
+	br := bufio.NewReader(r)
+	b, err := br.Peek(zstdSizeFrameLen)
+	if err != nil && !errors.Is(err, io.EOF) {
+		r.Close()
+		return nil, Info{}, false, fmt.Errorf("read header: %w", err)
+	}
+
+	rc := io.ReadCloser(struct {
+		io.Reader
+		io.Closer
+	}{br, r})
+
+	size, compressed := zstdParseSizeFrame(b)
+	if !compressed {
+		// Keep the reader seekable for the artifacts not compressed.
+		info.Seekable = false
+		if rs, ok := r.(io.ReadSeekCloser); ok {
+			if _, err := rs.Seek(0, io.SeekStart); err == nil {
+				rc = rs
+				info.Seekable = true
+			}
+		}
+
+		return rc, info, false, nil
+	}
+
+	br.Discard(zstdSizeFrameLen)
+
+	// Digest of the underlying store is of the compressed one.
+	info.Size = size
+	info.Digest = ""
+	info.Seekable = false
+
+	return rc, info, true, nil
+}
+
+func (s *zstdStore) Get(ctx context.Context, desc Description) (io.ReadCloser, Info, error) {
+	r, info, compressed, err := s.open(ctx, desc)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	if !compressed {
+		return r, info, nil
+	}
+
+	d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		r.Close()
+		return nil, Info{}, fmt.Errorf("create decoder: %w", err)
+	}
+
+	return &zstdReadCloser{Decoder: d, c: r}, info, nil
+}
+
+// Head reads the size frame of the artifact to report its original size.
+// The frame is read by `ReaderAt` if the underlying store implements it
+// so the artifact is not opened whole, e.g. verified or downloaded, only for its size.
+func (s *zstdStore) Head(ctx context.Context, desc Description) (Info, error) {
+	ra, ok := s.store.(ReaderAt)
+	if !ok {
+		r, info, _, err := s.open(ctx, desc)
+		if err != nil {
+			return Info{}, err
+		}
+
+		r.Close()
+		return info, nil
+	}
+
+	info, err := s.store.Head(ctx, desc)
+	if err != nil {
+		return Info{}, err
+	}
+
+	b := make([]byte, zstdSizeFrameLen)
+	n, err := ra.ReadAt(ctx, desc, b, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return Info{}, fmt.Errorf("read header: %w", err)
+	}
+	if size, ok := zstdParseSizeFrame(b[:n]); ok {
+		// Digest of the underlying store is of the compressed one.
+		info.Size = size
+		info.Digest = ""
+		info.Seekable = false
+	}
+
+	return info, nil
+}
+
+// Put spools the artifact to a temporary file in the spool directory so its size is known before it is compressed.
+func (s *zstdStore) Put(ctx context.Context, desc Description, r io.Reader) error {
+	if _, err := s.store.Head(ctx, desc); err == nil {
+		return ErrExist
+	}
+
+	if s.spool_dir != "" {
+		if err := os.MkdirAll(s.spool_dir, 0744); err != nil {
+			return fmt.Errorf("create spool directory: %w", err)
+		}
+	}
+
+	f, err := os.CreateTemp(s.spool_dir, "vcpkg-cache-http-")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	size, err := io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	e, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(s.level), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return fmt.Errorf("create encoder: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		if _, err := pw.Write(zstdSizeFrame(size)); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		e.ResetContentSize(pw, size)
+		_, err := io.Copy(e, f)
+		if err == nil {
+			err = e.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	err = s.store.Put(ctx, desc, pr)
+
+	// Unblock the encoder if the store returns before it consumes all, e.g. `ErrExist`.
+	pr.Close()
+	<-done
+
+	return err
+}
+
+func (s *zstdStore) Delete(ctx context.Context, desc Description) error {
+	return s.store.Delete(ctx, desc)
+}
+
+// List lists the entries in the underlying store.
+// Note that sizes of the entries are the compressed ones.
+func (s *zstdStore) List(ctx context.Context, fn func(entry Entry) error) error {
+	lister, ok := s.store.(Lister)
+	if !ok {
+		return errors.New("underlying store cannot enumerate its entries")
+	}
+
+	return lister.List(ctx, func(entry Entry) error {
+		// Digest of the underlying store is of the compressed one.
+		entry.Digest = ""
+		return fn(entry)
+	})
+}
+
+func (s *zstdStore) Close() error {
+	return s.store.Close()
+}
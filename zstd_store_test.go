@@ -0,0 +1,166 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	main "github.com/lesomnus/vcpkg-cache-http"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type ZstdStoreSetup struct{}
+
+func (s *ZstdStoreSetup) New(t *testing.T) (main.Store, error) {
+	store, err := NewTestFsStore(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return main.NewZstdStore(store), nil
+}
+
+func TestZstdStoreSuite(t *testing.T) {
+	suite.Run(t, &StoreTestSuite{Store: &ZstdStoreSetup{}})
+}
+
+func TestZstdStore(t *testing.T) {
+	t.Run("artifact is compressed at rest", func(t *testing.T) {
+		require := require.New(t)
+
+		fs_store, err := main.NewFsStore(t.TempDir())
+		require.NoError(err)
+
+		store := main.NewZstdStore(fs_store)
+
+		ctx := context.Background()
+		data := bytes.Repeat([]byte("Royale with Cheese"), 1000)
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		stat, err := os.Stat(fs_store.Resolve(DescriptionFoo))
+		require.NoError(err)
+		require.Less(stat.Size(), int64(len(data)/10))
+
+		info, err := store.Head(ctx, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(int64(len(data)), info.Size)
+
+		r, info, err := store.Get(ctx, DescriptionFoo)
+		require.NoError(err)
+		defer r.Close()
+		require.Equal(int64(len(data)), info.Size)
+
+		received, err := io.ReadAll(r)
+		require.NoError(err)
+		require.Equal(data, received)
+	})
+
+	t.Run("empty artifact", func(t *testing.T) {
+		require := require.New(t)
+
+		fs_store, err := NewTestFsStore(t)
+		require.NoError(err)
+
+		store := main.NewZstdStore(fs_store)
+
+		ctx := context.Background()
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(nil))
+		require.NoError(err)
+
+		info, err := store.Head(ctx, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(int64(0), info.Size)
+
+		received, err := readAll(ctx, store, DescriptionFoo)
+		require.NoError(err)
+		require.Empty(received)
+	})
+
+	t.Run("size is read without verifying the artifact", func(t *testing.T) {
+		require := require.New(t)
+
+		fs_store, err := main.NewFsStore(t.TempDir())
+		require.NoError(err)
+
+		store := main.NewZstdStore(fs_store)
+
+		ctx := context.Background()
+		data := randomData(t)
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		// Corrupt the artifact after the size frame.
+		f, err := os.OpenFile(fs_store.Resolve(DescriptionFoo), os.O_WRONLY|os.O_APPEND, 0)
+		require.NoError(err)
+		_, err = f.Write([]byte("Le Big Mac"))
+		require.NoError(err)
+		require.NoError(f.Close())
+
+		info, err := store.Head(ctx, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(int64(len(data)), info.Size)
+
+		_, _, err = store.Get(ctx, DescriptionFoo)
+		require.ErrorIs(err, main.ErrCorrupted)
+	})
+
+	t.Run("upload is spooled to the spool directory", func(t *testing.T) {
+		require := require.New(t)
+
+		fs_store, err := NewTestFsStore(t)
+		require.NoError(err)
+
+		spool := filepath.Join(t.TempDir(), "spool")
+		spooled := 0
+		store := main.NewZstdStore(&spyingStore{Store: fs_store, put: func(r io.Reader) {
+			entries, err := os.ReadDir(spool)
+			if err == nil {
+				spooled = len(entries)
+			}
+		}}, main.WithZstdSpoolDir(spool))
+
+		ctx := context.Background()
+		err = store.Put(ctx, DescriptionFoo, bytes.NewReader(randomData(t)))
+		require.NoError(err)
+		require.Equal(1, spooled)
+
+		// Spooled file is removed.
+		entries, err := os.ReadDir(spool)
+		require.NoError(err)
+		require.Empty(entries)
+	})
+
+	t.Run("artifact stored without compression is served as it is", func(t *testing.T) {
+		require := require.New(t)
+
+		fs_store, err := NewTestFsStore(t)
+		require.NoError(err)
+
+		ctx := context.Background()
+		data := randomData(t)
+		err = fs_store.Put(ctx, DescriptionFoo, bytes.NewReader(data))
+		require.NoError(err)
+
+		store := main.NewZstdStore(fs_store)
+
+		info, err := store.Head(ctx, DescriptionFoo)
+		require.NoError(err)
+		require.Equal(int64(len(data)), info.Size)
+
+		r, _, err := store.Get(ctx, DescriptionFoo)
+		require.NoError(err)
+		defer r.Close()
+
+		_, ok := r.(io.Seeker)
+		require.True(ok)
+
+		received, err := io.ReadAll(r)
+		require.NoError(err)
+		require.Equal(data, received)
+	})
+}